@@ -0,0 +1,402 @@
+// AC自动机(Aho-Corasick)
+// 开启ACMode之后编译时计算每个节点的失败链接和输出链接，内容检索只需要遍历一次内容
+// 插入和删除时只更新受影响的节点：新增的路径、失败链接经过新增或者删除节点的节点，以及输出链接经过变化的词结尾的节点
+// 链接没有生成或者已经失效时(例如整理之后)，在写锁之外重新计算，计算完成之前检索使用逐字查找
+// AC自动机需要词的每个字符都是节点，开启ACMode时不使用TAIL压缩
+
+package xtrie
+
+// AC自动机链接
+type acLinks struct {
+	fail  []int32 // 失败链接，当前节点匹配失败之后跳转的节点
+	out   []int32 // 输出链接，失败链接上最近的词结尾节点
+	depth []int32 // 节点的深度，即从根节点到该节点的字符数
+	head  []int32 // 失败链接的反向链表，head[f]为失败链接指向f的第一个节点
+	next  []int32 // 反向链表中的下一个节点
+	prev  []int32 // 反向链表中的上一个节点，删除节点时不需要从头查找
+	max   int     // 最大深度，删除词之后不减小
+	gen   uint64  // 链接对应的结构代数
+}
+
+// 是否使用TAIL压缩
+func (x *XTrie) _tailMode() bool {
+	return x.TailMode && !x.ACMode
+}
+
+// 按照层级遍历所有节点，计算失败链接和输出链接
+// 先按照父节点把所有节点分组，不需要逐个code查找子节点，复杂度O(Size)
+func (x *XTrie) _links() *acLinks {
+	size := x.Size
+	links := &acLinks{}
+	links._resize(size)
+	if size <= 1 {
+		return links
+	}
+	//按照父节点分组，first[p]到first[p+1]之间是节点p的子节点
+	first := make([]int32, size+1)
+	for i := 2; i < size; i++ {
+		if parent := x._parent(i); parent > 0 {
+			first[parent+1]++
+		}
+	}
+	for i := 1; i <= size; i++ {
+		first[i] += first[i-1]
+	}
+	children := make([]int32, first[size])
+	next := make([]int32, size)
+	copy(next, first[:size])
+	for i := 2; i < size; i++ {
+		if parent := x._parent(i); parent > 0 {
+			children[next[parent]] = int32(i)
+			next[parent]++
+		}
+	}
+
+	queue := make([]int32, 0, len(children)+1)
+	queue = append(queue, 1)
+	links.fail[1] = 1
+	for k := 0; k < len(queue); k++ {
+		parent := int(queue[k])
+		for _, c := range children[first[parent]:first[parent+1]] {
+			child := int(c)
+			code := child - x._base(parent)
+			fail := 1
+			if parent != 1 {
+				for f := int(links.fail[parent]); ; f = int(links.fail[f]) {
+					if ind := x._next(f, code); ind != 0 {
+						fail = ind
+						break
+					}
+					if f == 1 {
+						break
+					}
+				}
+			}
+			links._attach(child, fail)
+			if x._check(fail) < 0 {
+				links.out[child] = int32(fail)
+			} else {
+				links.out[child] = links.out[fail]
+			}
+			links.depth[child] = links.depth[parent] + 1
+			if int(links.depth[child]) > links.max {
+				links.max = int(links.depth[child])
+			}
+			queue = append(queue, c)
+		}
+	}
+	return links
+}
+
+// 节点的父节点，空闲位置和code为0存储词id的位置返回0
+func (x *XTrie) _parent(index int) int {
+	parent := x._check(index)
+	if parent < 0 {
+		parent = -parent
+	}
+	if parent == 0 || x._base(parent) == index {
+		return 0
+	}
+	return parent
+}
+
+// 修改结构之后链接没有增量更新时重新计算链接
+// 调用方持有wmu，不持有读写锁，计算过程中检索使用逐字查找
+func (x *XTrie) _relink() {
+	if !x.ACMode || x._linked() {
+		return
+	}
+	links := x._links()
+	links.gen = x.gen
+	x.mu.Lock()
+	x.links = links
+	x.mu.Unlock()
+}
+
+// 扩大或者截断链接数组，和结构的长度一致
+func (l *acLinks) _resize(size int) {
+	for _, a := range []*[]int32{&l.fail, &l.out, &l.depth, &l.head, &l.next, &l.prev} {
+		if len(*a) >= size {
+			*a = (*a)[:size:size]
+			continue
+		}
+		b := make([]int32, size)
+		copy(b, *a)
+		*a = b
+	}
+}
+
+// 深复制链接，快照共享链接时修改之前先复制
+func (l *acLinks) _copy() *acLinks {
+	tmp := &acLinks{max: l.max, gen: l.gen}
+	tmp._resize(len(l.fail))
+	copy(tmp.fail, l.fail)
+	copy(tmp.out, l.out)
+	copy(tmp.depth, l.depth)
+	copy(tmp.head, l.head)
+	copy(tmp.next, l.next)
+	copy(tmp.prev, l.prev)
+	return tmp
+}
+
+// 设置节点的失败链接，并加入到反向链表中
+func (l *acLinks) _attach(index int, fail int) {
+	l.fail[index] = int32(fail)
+	l.prev[index] = 0
+	l.next[index] = l.head[fail]
+	if l.head[fail] != 0 {
+		l.prev[l.head[fail]] = int32(index)
+	}
+	l.head[fail] = int32(index)
+}
+
+// 从失败链接的反向链表中删除节点
+func (l *acLinks) _detach(index int) {
+	prev, next := l.prev[index], l.next[index]
+	if prev != 0 {
+		l.next[prev] = next
+	} else {
+		l.head[l.fail[index]] = next
+	}
+	if next != 0 {
+		l.prev[next] = prev
+	}
+	l.fail[index], l.prev[index], l.next[index] = 0, 0, 0
+}
+
+// 链接是否需要随结构增量更新，链接已经失效时在修改之后重新计算
+func (x *XTrie) _acLive() bool {
+	return x.links != nil && len(x.links.fail) == x.Size
+}
+
+// 节点从from移动到to，指向from的链接改为指向to
+// 存储词id的位置不是节点，没有链接
+func (x *XTrie) _acMove(from int, to int) {
+	l := x.links
+	if l.fail[from] == 0 {
+		return
+	}
+	prev, next := l.prev[from], l.next[from]
+	if prev != 0 {
+		l.next[prev] = int32(to)
+	} else {
+		l.head[l.fail[from]] = int32(to)
+	}
+	if next != 0 {
+		l.prev[next] = int32(to)
+	}
+	l.fail[to], l.out[to], l.depth[to] = l.fail[from], l.out[from], l.depth[from]
+	l.prev[to], l.next[to], l.head[to] = prev, next, l.head[from]
+	for c := l.head[to]; c != 0; c = l.next[c] {
+		l.fail[c] = int32(to)
+	}
+	l.fail[from], l.out[from], l.depth[from] = 0, 0, 0
+	l.prev[from], l.next[from], l.head[from] = 0, 0, 0
+	if x._check(to) < 0 { //输出链接指向from的节点改为指向to
+		x._acSpread([]int32{int32(to)})
+	}
+}
+
+// 重新计算stack中节点在失败链接反向树中的所有后代的输出链接
+// 输出链接没有变化的节点以及词结尾节点的后代不受影响，直接跳过
+func (x *XTrie) _acSpread(stack []int32) {
+	l := x.links
+	for len(stack) > 0 {
+		index := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		out := l.out[index]
+		if x._check(int(index)) < 0 {
+			out = index
+		}
+		for c := l.head[index]; c != 0; c = l.next[c] {
+			if l.out[c] == out {
+				continue
+			}
+			l.out[c] = out
+			if x._check(int(c)) >= 0 {
+				stack = append(stack, c)
+			}
+		}
+	}
+}
+
+// 插入词之后计算新增节点的链接，词结尾节点已经标记
+// 新增节点按照深度从小到大计算失败链接，原来失败链接指向新节点最长后缀、并且以新节点为后缀的节点改为指向新节点
+// 参数 codes int切片 词的每个字符的code
+func (x *XTrie) _acInsert(codes []int) {
+	l := x.links
+	index := 1
+	for _, code := range codes {
+		parent := index
+		index = x._next(parent, code)
+		if l.fail[index] != 0 { //已有的节点
+			continue
+		}
+		fail := 1
+		if parent != 1 {
+			for f := int(l.fail[parent]); ; f = int(l.fail[f]) {
+				if ind := x._next(f, code); ind != 0 {
+					fail = ind
+					break
+				}
+				if f == 1 {
+					break
+				}
+			}
+		}
+		l.depth[index] = l.depth[parent] + 1
+		if int(l.depth[index]) > l.max {
+			l.max = int(l.depth[index])
+		}
+		l.out[index] = l.out[fail]
+		if x._check(fail) < 0 {
+			l.out[index] = int32(fail)
+		}
+		//新节点没有之前，以新节点为后缀的节点的失败链接都指向fail
+		for c := int(l.head[fail]); c != 0; {
+			next := int(l.next[c])
+			if l.depth[c] > l.depth[index] && x._acSuffix(c, index) {
+				l._detach(c)
+				l._attach(c, index)
+			}
+			c = next
+		}
+		l._attach(index, fail)
+	}
+	x._acSpread([]int32{int32(index)})
+}
+
+// 节点a对应的字符串是否以节点b对应的字符串结尾，a的深度大于b
+func (x *XTrie) _acSuffix(a int, b int) bool {
+	for b != 1 {
+		pa, pb := x._check(a), x._check(b)
+		if pa < 0 {
+			pa = -pa
+		}
+		if pb < 0 {
+			pb = -pb
+		}
+		if a-x._base(pa) != b-x._base(pb) {
+			return false
+		}
+		a, b = pa, pb
+	}
+	return true
+}
+
+// 回收节点之前删除节点的链接，失败链接指向该节点的节点改为指向它的失败链接
+func (x *XTrie) _acUnlink(index int) {
+	l := x.links
+	if l.fail[index] == 0 {
+		return
+	}
+	fail := int(l.fail[index])
+	out := l.out[fail]
+	if x._check(fail) < 0 {
+		out = int32(fail)
+	}
+	var stack []int32
+	for c := int(l.head[index]); c != 0; {
+		next := int(l.next[c])
+		l._attach(c, fail)
+		if l.out[c] == int32(index) { //输出链接经过删除的词结尾
+			l.out[c] = out
+			if x._check(c) >= 0 {
+				stack = append(stack, int32(c))
+			}
+		}
+		c = next
+	}
+	l.head[index] = 0
+	l._detach(index)
+	l.out[index], l.depth[index] = 0, 0
+	x._acSpread(stack)
+}
+
+// 链接是否和当前结构一致
+func (x *XTrie) _linked() bool {
+	return x.ACMode && x.links != nil && x.links.gen == x.gen
+}
+
+// AC自动机内容检索，遍历一次内容，回调的词和顺序与逐字查找一致
+// 命中的词在结尾位置才能确定，之后的词最早从当前位置向前max-1个字符开始，只缓存在这之后开始的词用于排序
+// fn返回false时返回false
+func (x *XTrie) _acEach(key string, o *searchOptions, fn func(MatchResult) bool) bool {
+	emit := fn
+	var sel *selector
+	if o.mode != MatchAll { //不重叠的模式按照开始位置逐个选出词
+		sel = &selector{x: x, o: o, fn: fn}
+		emit = sel._push
+	}
+	links := x.links
+	//最近max个字符的开始位置，根据词的字符数找到词的开始位置
+	var startBuf [32]int
+	var pendingBuf [16]MatchResult
+	starts := startBuf[:]
+	if links.max+1 > len(startBuf) {
+		starts = make([]int, links.max+1)
+	}
+	starts = starts[:links.max+1]
+	pending := pendingBuf[:0] //按照开始位置排序，还不能确定顺序的词
+	state := 1
+	for i, k := 0, 0; i < len(key); k++ {
+		kv, size := x._unit(key, i)
+		starts[k%len(starts)] = i
+		code := x._code(kv)
+		for code != 0 { //沿着失败链接查找可以接受当前字符的节点
+			if ind := x._next(state, code); ind != 0 {
+				state = ind
+				break
+			}
+			if state == 1 {
+				break
+			}
+			state = int(links.fail[state])
+		}
+		if code == 0 { //词库中没有的字符
+			state = 1
+		}
+		i += size
+		node := state
+		if x._check(node) >= 0 {
+			node = int(links.out[node])
+		}
+		for ; node > 1; node = int(links.out[node]) {
+			if !o._accept(x._level(node)) { //等级不满足条件的词跳过
+				continue
+			}
+			start := starts[(k+1-int(links.depth[node]))%len(starts)]
+			//同一个位置结束的词越来越短，之前结束的词更短，开始位置更大的词向后移动
+			j := len(pending)
+			pending = append(pending, MatchResult{})
+			for ; j > 0 && pending[j-1].Start > start; j-- {
+				pending[j] = pending[j-1]
+			}
+			pending[j] = MatchResult{Word: key[start:i], Level: x._level(node), Start: start, End: i}
+		}
+		//之后命中的词开始位置不小于limit，之前开始的词可以回调
+		limit := i
+		if links.max > 1 {
+			limit = 0
+			if p := k + 2 - links.max; p >= 0 {
+				limit = starts[p%len(starts)]
+			}
+		}
+		n := 0
+		for ; n < len(pending) && pending[n].Start < limit; n++ {
+			if !emit(pending[n]) {
+				return false
+			}
+		}
+		pending = pending[:copy(pending, pending[n:])]
+	}
+	for _, r := range pending {
+		if !emit(r) {
+			return false
+		}
+	}
+	if sel != nil {
+		return sel._flush()
+	}
+	return true
+}
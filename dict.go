@@ -7,6 +7,7 @@ package xtrie
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"io"
@@ -15,7 +16,29 @@ import (
 )
 
 // 读取文件加载词库
+// 词典文件没有变化返回true，有变化时在新的结构上编译，编译完成之后替换当前结构
 func (x *XTrie) DictRead () (bool,error) {
+	x.wmu.Lock()
+	defer x.wmu.Unlock()
+	tmp, err := x._dictRead()
+	if tmp == nil {
+		return err == nil, err
+	}
+	return false, x._rebuild(tmp)
+}
+
+// 读取词典文件到新的结构中，词典文件没有变化时返回nil
+func (x *XTrie) _dictRead() (*XTrie, error) {
+	tmp := x._new()
+	status, err := tmp._readDict()
+	if status || err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// 读取词典文件，词典文件md5没有变化返回true
+func (x *XTrie) _readDict() (bool,error) {
 	f, err := os.Open(x.DictFile)
 	if err != nil {
 		return false, err
@@ -33,20 +56,17 @@ func (x *XTrie) DictRead () (bool,error) {
 	}
 
 	x.Keymap = make(map[string]int)
+	x.Levels = make([]int, 0)
 	_, _ = f.Seek(0, 0)
 	bfRd := bufio.NewReader(f)
 
 	for {
 		line, err := bfRd.ReadBytes('\n')
-		lineLen := len(line)
 		if err != nil && err != io.EOF { //遇到任何错误立即返回，并忽略 EOF 错误信息
 			break
 		}
-		if lineLen >= 3 {//字符太少跳过处理
-			if line[lineLen-1] == '\n' {
-				lineLen -= 1
-			}
-			x.Keymap[string(line[2:lineLen])],_ = strconv.Atoi(string(line[0]))
+		if key, level, ok := _dictLine(line); ok {
+			x._setLevel(x._normalizeKey(key), level)
 		}
 		if err == io.EOF {
 			break
@@ -55,16 +75,34 @@ func (x *XTrie) DictRead () (bool,error) {
 	return false, err
 }
 
+// 解析词典行，格式为 "等级 词"，等级可以是任意int值
+func _dictLine(line []byte) (string, int, bool) {
+	line = bytes.TrimRight(line, "\r\n")
+	sp := bytes.IndexByte(line, ' ')
+	if sp < 1 || sp == len(line)-1 { //字符太少跳过处理
+		return "", 0, false
+	}
+	level, err := strconv.Atoi(string(line[:sp]))
+	if err != nil {
+		return "", 0, false
+	}
+	return string(line[sp+1:]), level, true
+}
+
 // 添加词
 // todo 写入需要判断最后的字符是不是换行
 func (x *XTrie) DictAdd (key string, level int) {
+	if x.DictFile == "" { //没有词典文件，不需要写入
+		return
+	}
 	fd,_:=os.OpenFile(x.DictFile, os.O_RDWR|os.O_CREATE|os.O_APPEND,os.ModePerm)
 	_, _ = fd.Write([]byte("\n"+strconv.Itoa(level) + " " + key))
 	defer fd.Close()
 }
 
 // 移除删除词并将处理后的文件内容写入临时文件中
-func _dictRemove(key string, oldDictFile string, tmpDictFile string) error {
+// 词典中的词规范化之后和key相同时移除
+func (x *XTrie) _dictRemove(key string, oldDictFile string, tmpDictFile string) error {
 	f, err := os.Open(oldDictFile)
 	if err != nil {
 		return err
@@ -78,11 +116,10 @@ func _dictRemove(key string, oldDictFile string, tmpDictFile string) error {
 	bfRd := bufio.NewReader(f)
 	for {
 		line, err := bfRd.ReadBytes('\n')
-		lineLen := len(line)
 		if err != nil && err != io.EOF { //遇到任何错误立即返回，并忽略 EOF 错误信息
 			return err
 		}
-		if lineLen<4 || string(line[2:len(line)-1]) == key {
+		if lineKey, _, ok := _dictLine(line); !ok || x._normalizeKey(lineKey) == key {
 			if err == io.EOF {
 				break
 			}
@@ -98,7 +135,10 @@ func _dictRemove(key string, oldDictFile string, tmpDictFile string) error {
 
 // 移除词
 func (x *XTrie) DictRemove (key string) error {
-	err := _dictRemove(key, x.DictFile, x.DictFile+"_tmp")
+	if x.DictFile == "" { //没有词典文件，不需要移除
+		return nil
+	}
+	err := x._dictRemove(x._normalizeKey(key), x.DictFile, x.DictFile+"_tmp")
 	if err != nil {
 		return err
 	}
@@ -1,64 +1,73 @@
 // 扩展增加词库管理和检索方法
 // 支持如下的检索模式
 // Match:完全匹配检索词，判断词是否存在以及获取词相关的数据，复杂度O(n)
-// Search:内容检索模式，将内容进行任意拆解组合进行查找词，复杂度O(2n)
+// Search:内容检索模式，将内容进行任意拆解组合进行查找词，复杂度O(2n)，可以选择返回所有重叠的词或者不重叠的词
 // Prefix:前缀检索模式，参数作为前缀，检索相同前缀的词，复杂度O(1)至O(root)
 // Suffix:后缀检索模式，参数作为后缀，检索相同后缀的词，复杂度O(1)至O(root)，因检索原理不同，比前缀查找要快
 // Fuzzy:模糊查找，将内容进行任意拆解组合，只要词库满足包含某一个字符，检索出相关词
+// 开启ACMode时内容检索使用AC自动机，复杂度O(n+m)，n为内容长度，m为命中的词数
+// 开启TailMode时没有分支的后缀存储在Tail中，以上检索到达有后缀的节点之后直接比较后缀
 // 支持管理功能：
-// Insert:插入词，根据词和词库的匹配程度，复杂度不等，最快O(1)，最慢需要重新构建整个结构
-// Delete:删除词，复杂度O(1)
-// todo 优化插入词方法，提高检索效率
+// Insert:插入词，逐个字符添加节点，位置冲突时移动较少的一组兄弟节点，复杂度接近O(n)
+// Delete:删除词，并回收不再通向任何词的节点，复杂度O(n)
 
 package xtrie
 
 import (
+	"bytes"
+	"cmp"
 	"errors"
+	"slices"
+	"unicode/utf8"
 )
 
 // 查询返回值结构体
 // 使用结构体可以保证检索结果的顺序，使用map结构无法保证顺序
 type MatchResult struct {
-	Word string `json:"word"`
-	Level int	`json:"level"`
+	Word      string `json:"word"`       // 词库中的词，跳过干扰字符时和内容中的原文不同
+	Level     int    `json:"level"`      // 词等级(权重)，任意int值
+	Start     int    `json:"start"`      // 内容检索和模糊检索时命中在内容中的字节位置，包括
+	End       int    `json:"end"`        // 内容检索和模糊检索时命中在内容中的字节位置，不包括
+	RuneStart int    `json:"rune_start"` // 命中在内容中的字符位置，包括
+	RuneEnd   int    `json:"rune_end"`   // 命中在内容中的字符位置，不包括
+}
+
+// 根据字节位置计算字符位置
+// result需要按照开始位置排序，只遍历一次内容
+func _runeOffsets(key string, result []MatchResult) {
+	pos, runes := 0, 0
+	for i := range result {
+		r := &result[i]
+		if r.Start < pos {
+			pos, runes = 0, 0
+		}
+		runes += utf8.RuneCountInString(key[pos:r.Start])
+		pos = r.Start
+		r.RuneStart = runes
+		r.RuneEnd = runes + utf8.RuneCountInString(key[r.Start:r.End])
+	}
 }
 
 // 判断是否是上下级关系
 func (x *XTrie) _upperAndLower(preIndex, index int) error {
-	if x.Check[index] != preIndex && -x.Check[index] != preIndex { // 说明上一个字符和当前字符不是上下级关系
+	if x._check(index) != preIndex && -x._check(index) != preIndex { // 说明上一个字符和当前字符不是上下级关系
 		return errors.New("not superior and subordinate")
 	}
 	return nil
 }
 
-// 获取上级索引，本级或下级偏移量，本级词登等级
+// 获取上级索引，本级或下级偏移量，本级词等级
 func (x *XTrie) _getIndexOffset(index int, currentOffset bool) (preIndex, offset, level int) {
-
-	if x.Base[index] > 0 && x.Check[index] < 0 {
-		offset = x.Base[index]/10
-		level = x.Base[index]%10
-		preIndex = -x.Check[index]
-	} else {
-		if x.Check[index] > 0 {
-			offset = x.Base[index]
-			level = 0
-			preIndex = x.Check[index]
-		} else {
-			offset = 0
-			level = -x.Base[index]
-			preIndex = -x.Check[index]
-		}
+	preIndex = x._check(index)
+	if preIndex < 0 {
+		preIndex = -preIndex
+	}
+	if x._base(index) > 0 {
+		offset = x._base(index)
 	}
+	level = x._level(index)
 	if currentOffset && preIndex > 0 {
-		if x.Check[preIndex] >= 0 {
-			offset = x.Base[preIndex]
-		} else {
-			if x.Base[preIndex] > 0 {
-				offset = x.Base[preIndex]/10
-			} else {
-				offset = -x.Base[preIndex]
-			}
-		}
+		offset = x._base(preIndex)
 	}
 	return preIndex, offset, level
 }
@@ -66,15 +75,49 @@ func (x *XTrie) _getIndexOffset(index int, currentOffset bool) (preIndex, offset
 // 查找搜索的词是否在词库-精确查找
 // 参数 key string 查找的词
 // 返回 最后一个字符的索引，偏移量，等级，error
-func (x *XTrie) Match (key string, forceBack bool) (int,int,error) {
-	keys, offset, index, level := []rune(key), x.Base[1], 1, 0
+// forceBack为true时查找的词只是其他词的前缀也返回成功，等级为0
+func (x *XTrie) Match(key string, forceBack bool) (int,int,error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x._match(key, forceBack)
+}
 
-	for k,kv := range keys {
+func (x *XTrie) _match(key string, forceBack bool) (int,int,error) {
+	key = x._normalizeKey(key)
+	index, level := 1, 0
+	if x.Size <= index {
+		return index, level, errors.New("dict is empty")
+	}
+
+	for i := 0; i < len(key); {
+		kv, size := x._unit(key, i)
 		if kv == 0 {
 			return index, level, errors.New("code error")
 		}
-		ind := offset + int(kv)
-		if ind >= x.Size { // 超过最大
+		if tail := x._tail(index); tail != nil { //剩余的字符在后缀中比较
+			k := 0
+			for ; i < len(key) && k < len(tail); k++ {
+				kv, size = x._unit(key, i)
+				if kv != tail[k] {
+					return index, level, errors.New("not found2")
+				}
+				i += size
+			}
+			if k < len(tail) { //在后缀中间结束，查找的词不是词结尾
+				level = 0
+			}
+			if i < len(key) || (!forceBack && k < len(tail)) {
+				return index, level, errors.New("not found2")
+			}
+			return index, level, nil
+		}
+		offset := x._base(index)
+		if offset <= 0 { //说明没有后续可查的值了，返回查询失败
+			return index, level, errors.New("not found2")
+		}
+		code := x._code(kv)
+		ind := offset + code
+		if code == 0 || ind >= x.Size { // 词库中没有的字符或者超过最大
 			return index, level, errors.New("code not set")
 		}
 		// 说明上一个字符和当前字符不是上下级关系
@@ -82,18 +125,18 @@ func (x *XTrie) Match (key string, forceBack bool) (int,int,error) {
 			return index, level, err
 		}
 		index = ind
-		_, offset, level = x._getIndexOffset(ind, false)
-		if k == len(keys) - 1 {//如果是最后一个字符
+		level = x._level(ind)
+		i += size
+		if i == len(key) {//如果是最后一个字符
+			if x._tail(ind) != nil { //后缀中还有字符，和后缀中间结束一样不是词结尾
+				level = 0
+			}
 			if forceBack { //强制返回模式，一定返回查找到的结果，除非没有结果
 				return index, level, nil
 			}
-			if x.Check[ind] > 0 { //查找到最后一个字符，但是还没到单个词的结尾
+			if x._check(ind) > 0 || x._tail(ind) != nil { //查找到最后一个字符，但是还没到单个词的结尾
 				return index, level, errors.New("not found1")
 			}
-		} else if x.Base[ind] < 0 {
-			//如果不是最后一个字符
-			//说明没有后续可查的值了，返回查询失败
-			return index, level, errors.New("not found2")
 		}
 	}
 	return index, level, nil
@@ -101,386 +144,571 @@ func (x *XTrie) Match (key string, forceBack bool) (int,int,error) {
 
 // 内容匹配模式查找
 // 可传入一段文本，逐字查找是否在词库中存在
-func (x *XTrie) Search (key string) []MatchResult {
-	Keys := []rune(key)
-	var start,index,offset,level int
-	var result []MatchResult
-	for k := range Keys {
-		start = -1
-		index = 1
-		offset = x.Base[index]
-		level = 0
-		for i:=k;i<len(Keys);i++ {
-			//词库没有该字符重置状态继续查找
-			ind := offset + int(Keys[i])
-			if ind > len(x.Base) { //越界base数组，结束查找
-				break
-			}
-			if err := x._upperAndLower(index, ind); err != nil{
-				start = -1
-				break
-			}
-			if start == -1 {
-				start = i
-			}
-			if x.Check[ind] < 0 { //说明该词是结尾标记
-				if x.Base[ind] > 0 && x.Check[ind] < 0 {
-					level = x.Base[ind] % 10
-				} else {
-					level = -x.Base[ind]
-				}
-				result = append(result, MatchResult{string(Keys[start:i + 1]), level})
-			}
-			if x.Base[ind] < 0 { //如果是结尾状态，没有后续词可查找
-				break
-			}
-			index = ind
-			if x.Base[ind] > 0 && x.Check[ind] < 0 {
-				offset = x.Base[ind]/10
-			} else {
-				offset = x.Base[ind]
-			}
+// 可选参数设置匹配模式，默认返回所有的词，包括相互重叠的词
+func (x *XTrie) Search(key string, opts ...SearchOption) []MatchResult {
+	o := _searchOptions(opts)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x._search(key, &o)
+}
+
+func (x *XTrie) _search(key string, o *searchOptions) []MatchResult {
+	text, offsets := x._normalize(key)
+	result := x._scan(text, o)
+	if offsets != nil { //位置对应到原始内容
+		for i := range result {
+			result[i].Start, result[i].End = offsets[result[i].Start], offsets[result[i].End]
 		}
 	}
+	_runeOffsets(key, result)
 	return result
 }
 
-// 前缀相同字符，添加剩余不同字符
-func (x *XTrie) _add(index int, key string,keys []rune, level int) error {
-	offset := 0
-	preLevel := -x.Base[index]
-	for k,v := range keys {
-		pos := int(v)
-		for {
-			pos++
-			if pos >= x.Size { //每次循环计算最大字符code位置是否超出范围
-				x.resize(int(float64(pos) * 1.25))
-			}
+// 在规范化之后的内容中查找所有的词
+func (x *XTrie) _scan(key string, o *searchOptions) []MatchResult {
+	var result []MatchResult
+	if x.Size <= 1 {
+		return result
+	}
+	x._each(key, o, func(r MatchResult) bool {
+		result = append(result, r)
+		return true
+	})
+	return result
+}
 
-			if x.Base[pos] != 0 || x.Check[pos] != 0{
-				continue
+// 查找内容中的词，按照开始位置顺序回调，fn返回false时停止查找
+// 开启AC自动机并且链接已经更新时只遍历一次内容，否则从每个位置开始逐字查找
+// 返回 是否查找完整个内容
+func (x *XTrie) _each(key string, o *searchOptions, fn func(MatchResult) bool) bool {
+	if x._linked() && o.skip == nil {
+		return x._acEach(key, o, fn)
+	}
+	for k := 0; k < len(key); {
+		end, ok := x._searchAt(key, k, o, fn)
+		if !ok {
+			return false
+		}
+		if o.mode != MatchAll && end > 0 { //不重叠的模式从命中词的结尾继续查找
+			k = end
+			continue
+		}
+		_, size := x._unit(key, k)
+		k += size
+	}
+	return true
+}
+
+// 查找从k位置开始的词，回调命中的词
+// MatchAll模式回调所有的词，其他模式只回调按照匹配模式选出的一个词
+// 返回 选出的词的结束位置，没有选出的词时为0；fn返回false时返回false
+func (x *XTrie) _searchAt(key string, k int, o *searchOptions, fn func(MatchResult) bool) (int, bool) {
+	var best MatchResult
+	bestID, found := 0, false
+	index, gap, skipped := 1, 0, false
+	for i := k; i < len(key); {
+		//词库没有该字符重置状态继续查找
+		kv, n := x._unit(key, i)
+		code, offset := x._code(kv), x._base(index)
+		ind := offset + code
+		if code == 0 || offset <= 0 || ind >= x.Size || x._upperAndLower(index, ind) != nil { //越界base数组或者不是子节点，结束查找
+			if o.skip != nil && index != 1 { //跳过词中间的干扰字符
+				if r, size := utf8.DecodeRuneInString(key[i:]); o.skip._skip(r, gap) {
+					i += size
+					gap++
+					skipped = true
+					continue
+				}
 			}
-			offset = pos - int(v)
-
-			if k == len(keys) - 1 {//如果是最后一个字符
-				x.Base[index] = offset
-				x.Base[pos]   = -level
-				x.Check[pos]  = -index
-			} else {
-				if k == 0 {
-					x.Base[index] = offset * 10 + preLevel
-				} else {
-					x.Base[index] = offset
+			break
+		}
+		i += n
+		gap = 0
+		end, word := -1, ""
+		tail := x._tail(ind)
+		accept := x._check(ind) < 0 && o._accept(x._level(ind)) //词结尾并且等级满足条件
+		if tail != nil && accept { //后缀也需要匹配
+			if o.skip != nil {
+				if e, ok, s := x._skipTail(key, i, tail, o.skip); ok {
+					end, word = e, key[k:e]
+					if skipped || s {
+						word = x._word(ind)
+					}
 				}
-				x.Base[pos]   = 0
-				x.Check[pos]  = index
+			} else if e, ok := x._hasTail(key, i, tail); ok {
+				end, word = e, key[k:e]
 			}
-			index = pos
+		} else if tail == nil && accept { //说明该词是结尾标记
+			end, word = i, key[k:i]
+			if skipped {
+				word = x._word(ind)
+			}
+		}
+		if end >= 0 {
+			r := MatchResult{Word: word, Level: x._level(ind), Start: k, End: end}
+			if o.mode == MatchAll {
+				if !fn(r) {
+					return 0, false
+				}
+			} else if id := x._wordID(ind); !found || o._prefer(r, best, id, bestID) {
+				best, bestID, found = r, id, true
+			}
+			if o.mode == MatchShortest { //最短的词已经找到
+				break
+			}
+		}
+		if tail != nil || x._base(ind) <= 0 { //如果是结尾状态，没有后续词可查找
 			break
 		}
+		index = ind
 	}
-	_ = x.Store(x.StoreFile)
-	x.DictAdd(key, level)
-	return nil
-}
-
-// 重置树结构，保持最优结构状态
-// 移动树结构并添加词
-func (x *XTrie) _addMove(keys string, level int) error {
-
-	x.Keymap[keys] = level
-
-	err := x.build()
-	if err != nil {
-		return errors.New("add key error" + err.Error())
-	}
-
-	err = x.Store(x.StoreFile)
-	if err != nil {
-		return errors.New("add key success, but store DAT is error" + err.Error())
+	if found {
+		return best.End, fn(best)
 	}
-
-	x.DictAdd(keys, level)
-
-	return nil
+	return 0, true
 }
 
 // 动态添加数据
-// 复杂度：可能是O(1)也可能是O(root)
+// 按照双数组的插入方式逐个字符添加节点，子节点位置冲突时移动子节点较少的一方(Aoe算法)
+// 复杂度：O(n)，n为词的长度，冲突时额外移动较少的一组兄弟节点
+// 设置了DictFile时追加一行到词典；只修改内存中的结构，需要持久化时调用Store
 func (x *XTrie) Insert(key string, level int) error {
-	//keys := []rune(key)
-	//先查找相同前缀的节点
-	//获取相同前缀最后的base status，开始添加数据
-	//读取已经入库相同前缀的词
-	keys, index, offset := []rune(key), 1, x.Base[1]
-	//先查找最长的相同前缀index
-	for k,kv := range keys {
+	x.wmu.Lock()
+	defer x.wmu.Unlock()
+	return x._insert(key, level)
+}
+
+func (x *XTrie) _insert(key string, level int) error {
+	word := x._normalizeKey(key) //词典中写入调用方传入的词
+	keys := x._units(word)
+	if len(keys) == 0 {
+		return errors.New("empty key")
+	}
+	for _, kv := range keys {
 		if kv == 0 {
 			return errors.New("code error")
 		}
-		ind := offset + int(kv)
-		if ind >= x.Size {
-			return x._addMove(key, level)
+	}
+
+	x._lock()
+	if index, _, err := x._match(word, false); err == nil { //已经存在的词只更新等级
+		x.gen++
+		x.Levels[x._wordID(index)] = level
+		x.mu.Unlock()
+		x.DictAdd(key, level) //读取词典时后面的行覆盖之前的等级
+		return nil
+	}
+	x.gen++
+	if x.Size <= 1 || len(x.Array) <= 2 { //空结构，初始化根节点
+		x.resize(2)
+	}
+	if x.Codes == nil && len(x.Keymap) == 0 && !x.ByteMode { //没有任何词，使用紧凑的字符映射
+		x.Codes = make(map[rune]int)
+		x.Runes = []rune{0}
+	}
+	codes := make([]int, len(keys))
+	for k, kv := range keys {
+		if x.Codes == nil { //没有字符映射的旧结构
+			codes[k] = int(kv)
+			if codes[k] > x.MaxCode {
+				x.MaxCode = codes[k]
+			}
+		} else {
+			codes[k] = x._addCode(kv)
 		}
-		if x.Check[ind] != index || -x.Check[ind] != index { // 说明上一个字符和当前字符不是上下级关系
-			return x._addMove(key, level)
+	}
+	//逐个字符查找子节点，没有的子节点直接添加
+	index := 1
+	for k, code := range codes {
+		if x._tail(index) != nil { //经过有后缀的节点，先展开后缀
+			index = x._untail(index)
 		}
-		if k == len(keys) - 1 {//如果是最后一个字符
-			if x.Check[ind] > 0 { //查找到最后一个字符，但是还没到单个词的结尾
-				x.Check[ind] = -x.Check[ind]
-				_ = x.Store(x.StoreFile)
-				x.DictAdd(key, level)
-			}
+		if x._tailMode() && k < len(keys)-1 && x._next(index, code) == 0 { //新的分支，剩余的字符存储在后缀中
+			index = x._child(index, code)
+			id := x._setLevel(word, level)
+			x._setTail(id, keys[k+1:])
+			x._setBase(index, -id - 1)
+			x._setCheck(index, -x._check(index))
+			x.mu.Unlock()
+			x._relink()
+			x.DictAdd(key, level)
 			return nil
-		} else {
-			if x.Base[ind] < 0 { //说明没有后续可查的值了
-				return x._add(index, key, keys[k:], level)
-			}
-			index = ind
-			if x.Check[ind] > 0 {
-				offset = x.Base[ind]
-			} else {
-				offset = x.Base[ind]/10
-			}
 		}
+		index = x._child(index, code)
+	}
+	if x._tail(index) != nil {
+		index = x._untail(index)
 	}
+	//标记词结尾，有子节点时词id存储在code为0的位置
+	id := x._setLevel(word, level)
+	if x._base(index) > 0 {
+		holder := x._child(index, 0)
+		index = x._check(holder) //存储词id的时候可能移动了当前节点
+		x._setBase(holder, -id - 1)
+	} else {
+		x._setBase(index, -id - 1)
+	}
+	x._setCheck(index, -x._check(index))
+	if x._acLive() {
+		x._acInsert(codes)
+		x.links.gen = x.gen
+	}
+	x.mu.Unlock()
+	x._relink()
+	x.DictAdd(key, level)
 	return nil
 }
 
-// 前缀查找，递归方法
-func (x *XTrie) _prefix (preStr string, index int, offset int, limit int, result *[]MatchResult) {
-	if len(*result) >= limit {//已经查够了不用再查询了
-		return
-	}
-	for i:=2; i<x.Size; i++{
-		check := x.Check[i]
-		if check != -index && check != index {
-			continue
-		}
-		str := string(rune(i-offset))
-		if check < 0 {
-			if len(*result) >= limit {
-				return
-			}
-			if x.Base[i] > 0 {
-				*result = append(*result, MatchResult{preStr + str, x.Base[i]%10})
-			} else {
-				*result = append(*result, MatchResult{preStr + str, -x.Base[i]})
+// 前缀查找，按照字典序深度优先遍历
+// 参数 buf byte切片 index节点对应的前缀，子节点在后面追加字符
+func (x *XTrie) _prefix(buf []byte, index int, o *searchOptions, fn wordFunc) bool {
+	tree := x._loadTree()
+	stack := x._pushChildren(make([]prefixNode, 0, 64), tree, index, len(buf))
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		i := node.index
+		buf = x._appendUnit(buf[:node.n], node.unit)
+		if x._check(i) < 0 && o._accept(x._level(i)) {
+			//后缀追加在buf之后，回调之后子节点的字符会覆盖后缀
+			if !fn(x._appendTail(buf, x._tail(i)), MatchResult{Level: x._level(i)}) {
+				return false
 			}
 		}
-		if x.Base[i] > 0 {
-			nextOffset := x.Base[i]
-			if check < 0 {
-				nextOffset = nextOffset/10
-			}
-			x._prefix(preStr + str, i, nextOffset, limit, result)
+		if x._base(i) > 0 {
+			stack = x._pushChildren(stack, tree, i, len(buf))
 		}
 	}
+	return true
 }
 
-// 前缀查找
-// 匹配搜索词所有相同前缀的词，算法复杂度较高，词不多的时候可以使用
-func (x *XTrie) Prefix(pre string, limit int) ([]MatchResult, error) {
-	index, level, err := x.Match(pre, true)
-	result := make([]MatchResult, 0, limit)
-	if err != nil {
-		return result, err
+// 前缀遍历的节点
+type prefixNode struct {
+	index int  // 节点索引
+	n     int  // 父节点对应的前缀长度
+	unit  rune // 节点对应的字符
+}
+
+// 把index的所有子节点按照字符从大到小追加到stack中，出栈的顺序是字典序
+// 参数 tree int32切片 子节点链接，nil时扫描整个数组，n int index对应的前缀长度
+func (x *XTrie) _pushChildren(stack []prefixNode, tree []int32, index int, n int) []prefixNode {
+	offset, start := x._base(index), len(stack)
+	if offset <= 0 {
+		return stack
 	}
-	nextOffset := x.Base[index]
-	if x.Check[index] < 0 { //说明搜索词是结束词
-		result = append(result, MatchResult{pre,level})
-		if nextOffset > 0{
-			nextOffset = nextOffset/10
+	if tree != nil { //直接遍历子节点链接，只访问index的子节点
+		for i := int(tree[index<<1]); i != 0; i = int(tree[i<<1|1]) {
+			if i != offset { //code为0的位置存储的是词id
+				stack = append(stack, prefixNode{i, n, x._rune(i - offset)})
+			}
 		}
-	}
-	if x.Base[index] < 0 {
-		return result, nil
-	}
-	x._prefix("", index, nextOffset, limit, &result)
-	for i:=0;i<len(result);i++ {
-		result[i].Word = pre + result[i].Word
-	}
-	if len(result) > 10 {
-		return result[0:limit], nil
 	} else {
-		return result, nil
+		for i := 2; i < x.Size; i++ {
+			check := x._check(i)
+			if check != -index && check != index {
+				continue
+			}
+			if i == offset { //code为0的位置存储的是词id
+				continue
+			}
+			stack = append(stack, prefixNode{i, n, x._rune(i - offset)})
+		}
 	}
+	slices.SortFunc(stack[start:], func(a, b prefixNode) int {
+		return cmp.Compare(b.unit, a.unit)
+	})
+	return stack
 }
 
-// 根据索引查找到的完整前缀字符串
-// 调用前缀查找到所有满足相同前缀的字符串
-func (x *XTrie) _fuzzyPrefix (index int, off int, limit int, result *[]MatchResult) {
-	if len(*result) >= limit {//已经查够了不用再查询了
+// 公共前缀查找，返回词库中所有是input前缀的词
+// 从根节点遍历一次input，结果按照长度从短到长，End和RuneEnd为词在input中的字节长度和字符长度
+func (x *XTrie) CommonPrefixes(input string, opts ...SearchOption) []MatchResult {
+	o := _searchOptions(opts)
+	o.mode = MatchAll
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var result []MatchResult
+	x._commonPrefixes(input, &o, func(r MatchResult) bool {
+		result = append(result, r)
+		return true
+	})
+	return result
+}
+
+// 最长前缀查找，返回词库中是input前缀的最长的词，没有时返回false
+func (x *XTrie) LongestPrefix(input string, opts ...SearchOption) (MatchResult, bool) {
+	o := _searchOptions(opts)
+	o.mode = MatchLeftmostLongest
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var result MatchResult
+	found := false
+	x._commonPrefixes(input, &o, func(r MatchResult) bool {
+		result, found = r, true
+		return true
+	})
+	return result, found
+}
+
+func (x *XTrie) _commonPrefixes(input string, o *searchOptions, fn func(MatchResult) bool) {
+	if x.Size <= 1 || input == "" {
 		return
 	}
-	//先查找前缀，然后通过前缀匹配查找所有满足条件的词
-	key := ""
-	currentOffset, preIndex, nextOffset := 0, index, x.Base[index]
-	if x.Check[index] == -1 {
-		if nextOffset > 0 {
-			*result = append(*result, MatchResult{string(rune(index-off)), nextOffset%10})
-		} else {
-			*result = append(*result, MatchResult{string(rune(index-off)), -nextOffset})
+	text, offsets := x._normalize(input)
+	pos, runes := 0, 0
+	x._searchAt(text, 0, o, func(r MatchResult) bool {
+		if offsets != nil { //长度对应到原始内容
+			r.End = offsets[r.End]
 		}
-	}
-	if nextOffset < 0 { //没有后续的词，结束查找
-		return
-	} else {
-		if x.Check[index] < 0 { //该字符是结束
-			nextOffset = nextOffset/10
+		runes += utf8.RuneCountInString(input[pos:r.End])
+		pos = r.End
+		r.RuneEnd = runes
+		return fn(r)
+	})
+}
+
+// 前缀查找
+// 匹配搜索词所有相同前缀的词，默认按照字典序返回最多limit个词，WithOrder设置排序方式，WithOffset设置分页的偏移量
+func (x *XTrie) Prefix(pre string, limit int, opts ...SearchOption) ([]MatchResult, error) {
+	o := _searchOptions(opts)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x._prefixWords(pre, limit, &o)
+}
+
+// 分页前缀查找，同Prefix，同时返回满足条件的词的总数
+func (x *XTrie) PrefixPage(pre string, limit int, opts ...SearchOption) ([]MatchResult, int, error) {
+	o := _searchOptions(opts)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x._prefixPage(pre, limit, &o)
+}
+
+func (x *XTrie) _prefixWords(pre string, limit int, o *searchOptions) ([]MatchResult, error) {
+	result, _, err := x._prefixPage(pre, limit, o)
+	return result, err
+}
+
+// 前缀查找的一页结果
+// 返回 offset之后的limit个词，满足条件的词的总数
+func (x *XTrie) _prefixPage(pre string, limit int, o *searchOptions) ([]MatchResult, int, error) {
+	result := make([]MatchResult, 0)
+	total := 0
+	lexical := o.order == OrderLexical
+	err := x._prefixEach(pre, o, func(word []byte, r MatchResult) bool {
+		total++
+		if lexical && (total <= o.offset || total > o.offset+limit) { //字典序不在这一页的词只计数
+			return true
 		}
+		r.Word = string(word)
+		result = append(result, r)
+		return true
+	})
+	if err != nil || lexical {
+		return result, total, err
 	}
-	tmpIndex := 0
-	for {
-		if preIndex == 1 { //说明已经找到了
-			break
+	//按照等级或者权重从高到低排序，相同时保持字典序
+	if o.order == OrderLevel {
+		slices.SortStableFunc(result, func(a, b MatchResult) int {
+			return cmp.Compare(b.Level, a.Level)
+		})
+	} else if o.weight != nil {
+		weights := make(map[string]float64, len(result))
+		for _, r := range result {
+			weights[r.Word] = o.weight(r)
 		}
-		tmpIndex, currentOffset, _ = x._getIndexOffset(preIndex, true)
-		key = string(rune(preIndex-currentOffset)) + key
-		preIndex = tmpIndex
+		slices.SortStableFunc(result, func(a, b MatchResult) int {
+			return cmp.Compare(weights[b.Word], weights[a.Word])
+		})
 	}
-	x._prefix(key, index, nextOffset, limit, result)
+	lo, hi := min(o.offset, len(result)), min(o.offset+max(limit, 0), len(result))
+	return slices.Clone(result[lo:hi]), total, nil
 }
 
 // 模糊查找
 // 命中规则，只要有字符是一样的就会返回，最少一个字符
-func (x *XTrie) Fuzzy (key string, limit int) ([]MatchResult, error) {
-	keys   := []rune(key)
+// 每个命中的字符在内容中出现的位置单独返回一个结果，同一个词可能返回多次
+func (x *XTrie) Fuzzy(key string, limit int, opts ...SearchOption) ([]MatchResult, error) {
+	o := _searchOptions(opts)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x._fuzzy(key, limit, &o)
+}
+
+func (x *XTrie) _fuzzy(key string, limit int, o *searchOptions) ([]MatchResult, error) {
 	result := make([]MatchResult, 0, 10)
-	offset := 0
-	preIndex := 0
-	for i:=2;i<x.Size;i++ {
-		preIndex, offset, _ = x._getIndexOffset(i, true)
-		if preIndex <= 0 {
-			continue
-		}
-		for _,v := range keys {
-			//判断是否相同字符
-			if int(v) == i-offset {
-				x._fuzzyPrefix(i, offset, limit, &result)
-				if len(result) >= limit {
-					return result, nil
-				}
-			}
-		}
+	if key == "" || limit <= 0 {
+		return result, nil
 	}
+	x._fuzzyEach(key, o, _keepWord(func(r MatchResult) bool {
+		result = append(result, r)
+		return len(result) < limit
+	}))
 	return result, nil
 }
 
-// 后缀匹配词
-// 返回查找到的字符串以及词等级
-// 算法复杂度，对比前缀搜索要低。根据匹配到的字符依次查找，词越长，查找消耗越大
-func (x *XTrie) Suffix (key string, limit int) ([]MatchResult, error) {
-	keys        := []rune(key)
-	lastRune    := int(keys[len(keys)-1])
-	suffixStart := make([]int, 0, 10)
-	offset      := 0
-	preIndex    := 0
-	result      := make([]MatchResult, 0, 10)
-	for i:=0;i<x.Size;i++ {
-		preIndex = -x.Check[i]
-		if preIndex < 0 {
+// 模糊查找
+func (x *XTrie) _fuzzyEach(key string, o *searchOptions, fn wordFunc) {
+	text, offsets := x._normalize(key)
+	buf := make([]byte, 0, 64)
+	for i:=2;i<x.Size;i++ {
+		if x._check(i) >= 0 || !o._accept(x._level(i)) { //只查找等级满足条件的词结尾节点
 			continue
 		}
-		if x.Base[preIndex] < 0 {
-			offset = -x.Base[preIndex]
-		} else {
-			offset = x.Base[preIndex]/10
-		}
-		//判断是否相同结尾字符
-		if lastRune != i - offset {
+		//还原完整的词之后判断是否有相同字符，后缀中的字符也可以命中
+		buf = x._appendWord(buf[:0], i)
+		if !bytes.ContainsAny(buf, text) {
 			continue
 		}
-		level := 0
-		if x.Base[i] > 0 {
-			level = x.Base[i]/10
-		} else {
-			level = -x.Base[i]
-		}
-		if preIndex == 1 {
-			result = append(result, MatchResult{string(rune(lastRune)), level})
-		} else {
-			suffixStart = append(suffixStart, preIndex, level)
+		runes := 0
+		for k, r := range text {
+			if bytes.ContainsRune(buf, r) {
+				_, size := utf8.DecodeRuneInString(text[k:]) //无效的字节解码为RuneError，只占用一个字节
+				start, end := k, k+size
+				if offsets != nil { //位置对应到原始内容
+					start, end = offsets[start], offsets[end]
+				}
+				if !fn(buf, MatchResult{"", x._level(i), start, end, runes, runes + 1}) {
+					return
+				}
+			}
+			runes++
 		}
 	}
+}
+
+// 根据节点索引向上查找，还原完整的词
+func (x *XTrie) _word(index int) string {
+	return string(x._appendWord(nil, index))
+}
 
-	if len(suffixStart) == 0 {
-		if len(result) > 0 {
-			return result, nil
+// 把节点对应的完整的词追加到buf中，包括后缀
+// 先计算词的长度，再从后向前写入每个字符，不需要额外的缓冲区
+func (x *XTrie) _appendWord(buf []byte, index int) []byte {
+	n := 0
+	for i := index; i > 1; {
+		preIndex, offset, _ := x._getIndexOffset(i, true)
+		n += x._unitLen(x._rune(i-offset))
+		i = preIndex
+	}
+	buf = append(buf, make([]byte, n)...)
+	pos := len(buf)
+	for i := index; i > 1; {
+		preIndex, offset, _ := x._getIndexOffset(i, true)
+		r := x._rune(i - offset)
+		pos -= x._unitLen(r)
+		if x.ByteMode {
+			buf[pos] = byte(r)
 		} else {
-			return result, errors.New("not found")
+			utf8.EncodeRune(buf[pos:], r)
 		}
+		i = preIndex
 	}
+	return x._appendTail(buf, x._tail(index))
+}
 
-	for i:=0;i<len(suffixStart);i+=2 {
-		index := suffixStart[i]
-		z := len(keys) - 2
-		c := false
-		str := ""
-		for {
-			if z < 0 { //已找到最后要匹配的字符
-				index = 0
-				break
-			} else {
-				if index == 1 { //找到root节点了
-					break
-				}
-				preIndex, offset, _ = x._getIndexOffset(index, true)
-				//判断是否相同结尾字符
-				if c == false && int(keys[z]) != index - offset {
-					index = 0
-					break
-				}
-				str  += string(rune(index-offset))
-				index = preIndex
-				if z == 0 { //找到最后一个字符，还没有找到完整的词
-					c = true
-					continue
-				}
-				z--
-			}
+// 后缀匹配词
+// 返回查找到的字符串以及词等级
+// 根据结尾字符相同的词结尾节点向上还原词，词越长，查找消耗越大
+func (x *XTrie) Suffix(key string, limit int, opts ...SearchOption) ([]MatchResult, error) {
+	o := _searchOptions(opts)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x._suffix(key, limit, &o)
+}
 
+func (x *XTrie) _suffix(key string, limit int, o *searchOptions) ([]MatchResult, error) {
+	result := make([]MatchResult, 0, 10)
+	if key == "" {
+		return result, errors.New("empty key")
+	}
+	x._suffixEach(key, o, func(word []byte, r MatchResult) bool {
+		r.Word = string(word)
+		result = append(result, r)
+		return len(result) != limit
+	})
+	if len(result) == 0 {
+		return result, errors.New("not found")
+	}
+	return result, nil
+}
+
+// 后缀查找
+func (x *XTrie) _suffixEach(key string, o *searchOptions, fn wordFunc) {
+	key = x._normalizeKey(key)
+	if key == "" {
+		return
+	}
+	lastRune, _ := x._lastUnit(key)
+	suffix := []byte(key)
+	buf := make([]byte, 0, 64)
+	for i:=2;i<x.Size;i++ {
+		preIndex := -x._check(i)
+		if preIndex <= 0 {
+			continue
 		}
-		if index >= 0 { //如果找到词
-			result = append(result, MatchResult{string(rune(lastRune))+str, suffixStart[i+1]})
-			if len(result) == limit {
-				break
-			}
+		//判断是否相同结尾字符，有后缀的节点结尾字符是后缀的最后一个字符
+		last := x._rune(i - x._base(preIndex))
+		if tail := x._tail(i); tail != nil {
+			last = tail[len(tail)-1]
+		}
+		if lastRune != last || !o._accept(x._level(i)) {
+			continue
+		}
+		buf = x._appendWord(buf[:0], i)
+		if !bytes.HasSuffix(buf, suffix) {
+			continue
+		}
+		if !fn(buf, MatchResult{Level: x._level(i)}) {
+			return
 		}
 	}
-	return result, nil
 }
 
 // 删除词
+// 删除词结尾标记之后向上回收不再通向任何词的节点，复杂度O(n)，n为词长度
+// 设置了DictFile时从词典中删除对应的行；和Insert一样只修改内存中的结构
 // 参数 key string 需要删除的词
 func (x *XTrie) Remove(key string) error {
+	x.wmu.Lock()
+	defer x.wmu.Unlock()
+	return x._remove(key)
+}
 
-	index, _, err := x.Match(key, false)
+func (x *XTrie) _remove(key string) error {
+	word := x._normalizeKey(key)
+	if word == "" {
+		return errors.New("empty key")
+	}
+	index, _, err := x._match(word, false)
 	if err != nil {
 		return err
 	}
-
-	if x.Base[index] > 0 { //该词还有子节点
-		if x.Check[index] < 0 { //说明是可结束状态
-			x.Check[index] = -x.Check[index]
-		}
-	} else { //没有子节点，直接清空数据
-		x.Base[index]  = 0
-		x.Check[index] = 0
+	if x._check(index) >= 0 { //不是词结尾
+		return errors.New("not found")
 	}
 
-	delete(x.Keymap, key)
-
-	err = x.format()
-	if err != nil {
-		return err
+	x._lock()
+	x.gen++
+	id := x._wordID(index)
+	if x._base(index) > 0 { //该词还有子节点，清除code为0位置存储的词id
+		x._free(x._base(index))
+		x._setCheck(index, -x._check(index))
+		if x._acLive() { //输出链接指向该节点的节点改为指向下一个词结尾
+			x._acSpread([]int32{int32(index)})
+		}
+	} else { //没有子节点，回收节点以及不再通向任何词的上级节点
+		if id < len(x.Tails) {
+			x.Tails[id] = 0
+		}
+		x._prune(index)
 	}
-
-	err = x.Store(x.StoreFile)
-	if err != nil {
-		return err
+	delete(x.Keymap, word)
+	x.Levels[id] = 0
+	if x._acLive() {
+		x.links.gen = x.gen
 	}
-
-	err = x.DictRemove(key)
-	return err
-}
\ No newline at end of file
+	x.mu.Unlock()
+	x._relink()
+	return x.DictRemove(key)
+}
@@ -1,3 +1,3 @@
 module github.com/jinxing3114/xtrie
 
-go 1.15
+go 1.23
@@ -0,0 +1,131 @@
+// 回调和迭代器检索
+// 不创建结果切片，每个命中的词直接回调，回调返回false时停止检索
+// SearchFunc的Word是内容的子串，不需要分配内存；PrefixFunc、SuffixFunc、FuzzyFunc需要还原词，每个词分配一次字符串，回调之后可以继续使用
+// 检索过程中持有读锁，回调中不能修改词库
+
+package xtrie
+
+import (
+	"iter"
+	"unicode/utf8"
+)
+
+// 遍历词库时的回调，word是还原的词，使用同一个缓冲区，只在回调期间有效，r.Word为空
+// 需要保留词时复制为字符串，不能把缓冲区转换为不复制数据的字符串
+type wordFunc func(word []byte, r MatchResult) bool
+
+// 回调之前把词复制为字符串，连续相同的词共用一个字符串
+func _keepWord(fn func(MatchResult) bool) wordFunc {
+	word := ""
+	return func(b []byte, r MatchResult) bool {
+		if string(b) != word {
+			word = string(b)
+		}
+		r.Word = word
+		return fn(r)
+	}
+}
+
+// 内容检索，按照开始位置顺序回调命中的词，结果和Search一致
+// 开启ACMode时同样只遍历一次内容，只缓存还不能确定顺序的词
+func (x *XTrie) SearchFunc(text string, fn func(MatchResult) bool, opts ...SearchOption) {
+	o := _searchOptions(opts)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	x._searchFunc(text, &o, fn)
+}
+
+// 前缀检索，按照字典序回调前缀下所有的词，不使用排序和分页选项
+func (x *XTrie) PrefixFunc(pre string, fn func(MatchResult) bool, opts ...SearchOption) {
+	o := _searchOptions(opts)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_ = x._prefixEach(pre, &o, _keepWord(fn))
+}
+
+// 后缀检索，回调以key结尾的所有的词
+func (x *XTrie) SuffixFunc(key string, fn func(MatchResult) bool, opts ...SearchOption) {
+	o := _searchOptions(opts)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	x._suffixEach(key, &o, _keepWord(fn))
+}
+
+// 模糊检索，回调内容中每个字符命中的词
+func (x *XTrie) FuzzyFunc(text string, fn func(MatchResult) bool, opts ...SearchOption) {
+	o := _searchOptions(opts)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	x._fuzzyEach(text, &o, _keepWord(fn))
+}
+
+// SearchFunc的迭代器
+func (x *XTrie) SearchSeq(text string, opts ...SearchOption) iter.Seq[MatchResult] {
+	return func(yield func(MatchResult) bool) {
+		x.SearchFunc(text, yield, opts...)
+	}
+}
+
+// PrefixFunc的迭代器
+func (x *XTrie) PrefixSeq(pre string, opts ...SearchOption) iter.Seq[MatchResult] {
+	return func(yield func(MatchResult) bool) {
+		x.PrefixFunc(pre, yield, opts...)
+	}
+}
+
+// SuffixFunc的迭代器
+func (x *XTrie) SuffixSeq(key string, opts ...SearchOption) iter.Seq[MatchResult] {
+	return func(yield func(MatchResult) bool) {
+		x.SuffixFunc(key, yield, opts...)
+	}
+}
+
+// FuzzyFunc的迭代器
+func (x *XTrie) FuzzySeq(text string, opts ...SearchOption) iter.Seq[MatchResult] {
+	return func(yield func(MatchResult) bool) {
+		x.FuzzyFunc(text, yield, opts...)
+	}
+}
+
+func (x *XTrie) _searchFunc(key string, o *searchOptions, fn func(MatchResult) bool) {
+	if x.Size <= 1 {
+		return
+	}
+	text, offsets := x._normalize(key)
+	pos, runes := 0, 0
+	x._each(text, o, func(r MatchResult) bool {
+		if offsets != nil { //位置对应到原始内容
+			r.Start, r.End = offsets[r.Start], offsets[r.End]
+		}
+		//结果按照开始位置顺序，字符位置从上一个结果继续计算
+		runes += utf8.RuneCountInString(key[pos:r.Start])
+		pos = r.Start
+		r.RuneStart = runes
+		r.RuneEnd = runes + utf8.RuneCountInString(key[r.Start:r.End])
+		return fn(r)
+	})
+}
+
+// 前缀下所有的词，顺序和Prefix一致
+func (x *XTrie) _prefixEach(pre string, o *searchOptions, fn wordFunc) error {
+	pre = x._normalizeKey(pre)
+	index, level, err := x._match(pre, true)
+	if err != nil {
+		return err
+	}
+	if x._tail(index) != nil { //搜索词在后缀中结束，只有一个词，等级是后缀所在词的等级
+		if level = x._level(index); o._accept(level) {
+			fn(x._appendWord(nil, index), MatchResult{Level: level})
+		}
+		return nil
+	}
+	buf := make([]byte, len(pre), len(pre)+64)
+	copy(buf, pre)
+	if x._check(index) < 0 && o._accept(level) && !fn(buf, MatchResult{Level: level}) {
+		return nil
+	}
+	if x._base(index) > 0 {
+		x._prefix(buf, index, o, fn)
+	}
+	return nil
+}
@@ -2,7 +2,17 @@ package xtrie
 
 import (
 	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"unicode"
+	"unicode/utf8"
 )
 
 //创建XTrie
@@ -43,4 +53,1440 @@ func TestInit(t *testing.T){
 	//for i:=0;i<len(result);i++{
 	//	fmt.Println("str:", string(contentRune[result[i][0]:result[i][1] + 1]), "level", result[i][2])
 	//}
-}
\ No newline at end of file
+}
+// 根据词典行创建临时词典并初始化XTrie
+// xt为设置好ACMode、TailMode、Normalizer等配置的结构，nil时使用默认配置
+func newTestTrie(t *testing.T, xt *XTrie, lines ...string) *XTrie {
+	dir := t.TempDir()
+	dict := filepath.Join(dir, "dict.txt")
+	if err := os.WriteFile(dict, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if xt == nil {
+		xt = new(XTrie)
+	}
+	xt.InitHandle(filepath.Join(dir, "dat.data"), dict)
+	return xt
+}
+
+func TestLevel(t *testing.T) {
+	xt := newTestTrie(t, nil, "12 中国", "-3 中国人", "1000000 国人", "0 a", "42 ab")
+
+	cases := map[string]int{"中国": 12, "中国人": -3, "国人": 1000000, "a": 0, "ab": 42}
+	for word, want := range cases {
+		_, level, err := xt.Match(word, false)
+		if err != nil || level != want {
+			t.Errorf("Match(%q) = %d, %v; want %d", word, level, err, want)
+		}
+	}
+	if _, _, err := xt.Match("中", false); err == nil {
+		t.Errorf("Match(中) should not be found")
+	}
+
+	search := xt.Search("我是中国人")
+	want := []MatchResult{{"中国", 12, 6, 12, 2, 4}, {"中国人", -3, 6, 15, 2, 5}, {"国人", 1000000, 9, 15, 3, 5}}
+	if !reflect.DeepEqual(search, want) {
+		t.Errorf("Search = %v; want %v", search, want)
+	}
+
+	prefix, err := xt.Prefix("中", 10)
+	if err != nil || !reflect.DeepEqual(prefix, []MatchResult{{Word: "中国", Level: 12}, {Word: "中国人", Level: -3}}) {
+		t.Errorf("Prefix = %v, %v", prefix, err)
+	}
+
+	suffix, err := xt.Suffix("国人", 10)
+	if err != nil || len(suffix) != 2 {
+		t.Errorf("Suffix = %v, %v", suffix, err)
+	}
+	for _, r := range suffix {
+		if r.Level != cases[r.Word] {
+			t.Errorf("Suffix level %v; want %d", r, cases[r.Word])
+		}
+	}
+
+	if err := xt.Insert("abc", 77); err != nil {
+		t.Fatal(err)
+	}
+	if _, level, err := xt.Match("abc", false); err != nil || level != 77 {
+		t.Errorf("Match(abc) after insert = %d, %v", level, err)
+	}
+	if _, level, _ := xt.Match("ab", false); level != 42 {
+		t.Errorf("Match(ab) after insert = %d", level)
+	}
+}
+
+type testValue struct {
+	Category    string
+	Replacement string
+}
+
+func TestValueTrie(t *testing.T) {
+	vt := NewValueTrie[testValue]()
+	vt.Add("中国", 3, testValue{"place", "**"})
+	vt.Add("中国人", 5, testValue{"people", "***"})
+	if err := vt.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vt.Insert("国人", 7, testValue{Category: "people"}); err != nil {
+		t.Fatal(err)
+	}
+
+	value, level, err := vt.Match("中国人")
+	if err != nil || level != 5 || value.Category != "people" {
+		t.Errorf("Match = %v, %d, %v", value, level, err)
+	}
+
+	search := vt.Search("我是中国人")
+	if len(search) != 3 {
+		t.Fatalf("Search = %v", search)
+	}
+	for _, r := range search {
+		v, _, _ := vt.Match(r.Word)
+		if r.Value != v {
+			t.Errorf("Search value of %q = %v; want %v", r.Word, r.Value, v)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "value.data")
+	if err := vt.Store(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := new(ValueTrie[testValue])
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	prefix, err := loaded.Prefix("中", 10)
+	if err != nil || len(prefix) != 2 || prefix[0].Value.Replacement != "**" || prefix[1].Value.Replacement != "***" {
+		t.Errorf("Prefix after load = %v, %v", prefix, err)
+	}
+
+	if err := loaded.Remove("中国"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loaded.Match("中国"); err == nil {
+		t.Errorf("Match(中国) after remove should fail")
+	}
+
+	//TAIL压缩的结构不能加载到AC自动机中
+	tail := NewValueTrie[testValue]()
+	tail.TailMode = true
+	for _, word := range []string{"中国", "中国人民共和国", "国人民"} {
+		tail.Add(word, 1, testValue{})
+	}
+	if err := tail.Build(); err != nil {
+		t.Fatal(err)
+	}
+	tailPath := filepath.Join(t.TempDir(), "tail.data")
+	if err := tail.Store(tailPath); err != nil {
+		t.Fatal(err)
+	}
+	ac := &ValueTrie[testValue]{XTrie: &XTrie{ACMode: true}}
+	if err := ac.Load(tailPath); err == nil {
+		t.Errorf("Load TailMode store into ACMode should fail")
+	}
+
+	//读取词典时词id重新分配，值按照词保留
+	dir := t.TempDir()
+	dict, store := filepath.Join(dir, "dict.txt"), filepath.Join(dir, "value.data")
+	if err := os.WriteFile(dict, []byte("1 国人\n2 中国\n3 新词"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dt := NewValueTrie[string]()
+	dt.Add("中国", 1, "place")
+	dt.Add("国人", 1, "people")
+	if err := dt.Build(); err != nil {
+		t.Fatal(err)
+	}
+	dt.InitHandle(store, dict)
+	want := map[string]string{"中国": "place", "国人": "people", "新词": ""}
+	for word, v := range want {
+		if got, _, err := dt.Match(word); err != nil || got != v {
+			t.Errorf("Match(%q) after InitHandle = %q, %v; want %q", word, got, err, v)
+		}
+	}
+	reload := new(ValueTrie[string])
+	reload.InitHandle(store, dict)
+	for word, v := range want {
+		if got, _, err := reload.Match(word); err != nil || got != v {
+			t.Errorf("Match(%q) after reload = %q, %v; want %q", word, got, err, v)
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	xt := newTestTrie(t, nil, "1 中国", "2 中国人", "3 国人")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if len(xt.Search("我是中国人")) < 3 {
+					t.Error("Search lost words during insert")
+					return
+				}
+				_, _ = xt.Prefix("中", 10)
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		if err := xt.Insert(fmt.Sprintf("词%d", i), i); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	for i := 0; i < 50; i++ {
+		if _, level, err := xt.Match(fmt.Sprintf("词%d", i), false); err != nil || level != i {
+			t.Errorf("Match(词%d) = %d, %v", i, level, err)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	xt := newTestTrie(t, nil, "1 中国", "2 中国人")
+
+	snap := xt.Snapshot()
+	if err := xt.Insert("国人", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := xt.Remove("中国"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := snap.Search("我是中国人"); !reflect.DeepEqual(got, []MatchResult{{"中国", 1, 6, 12, 2, 4}, {"中国人", 2, 6, 15, 2, 5}}) {
+		t.Errorf("snapshot Search = %v", got)
+	}
+	if got := xt.Search("我是中国人"); !reflect.DeepEqual(got, []MatchResult{{"中国人", 2, 6, 15, 2, 5}, {"国人", 3, 9, 15, 3, 5}}) {
+		t.Errorf("Search = %v", got)
+	}
+	if next := xt.Snapshot(); next.Generation <= snap.Generation {
+		t.Errorf("generation did not advance: %d <= %d", next.Generation, snap.Generation)
+	}
+
+	// 原地修改之前复制共享的数据
+	snap = xt.Snapshot()
+	if err := xt.Insert("中国人", 9); err != nil {
+		t.Fatal(err)
+	}
+	if _, level, _ := snap.Match("中国人", false); level != 2 {
+		t.Errorf("snapshot level = %d; want 2", level)
+	}
+	if _, level, _ := xt.Match("中国人", false); level != 9 {
+		t.Errorf("level = %d; want 9", level)
+	}
+	if next := xt.Snapshot(); next.Generation <= snap.Generation {
+		t.Errorf("level update did not advance generation: %d <= %d", next.Generation, snap.Generation)
+	}
+
+	vt := NewValueTrie[int]()
+	vt.Add("中国", 1, 1)
+	if err := vt.Build(); err != nil {
+		t.Fatal(err)
+	}
+	snap = vt.Snapshot()
+	vt.Add("中国", 2, 2)
+	if next := vt.Snapshot(); next.Generation <= snap.Generation {
+		t.Errorf("Add did not advance generation: %d <= %d", next.Generation, snap.Generation)
+	}
+}
+
+// 检查Base和Check的状态约定
+func checkTrie(t *testing.T, x *XTrie) {
+	t.Helper()
+	for i := 2; i < x.Size; i++ {
+		c := x._check(i)
+		if c == 0 {
+			if x._base(i) != 0 {
+				t.Fatalf("free slot %d base %d", i, x._base(i))
+			}
+			continue
+		}
+		p := c
+		if p < 0 {
+			p = -p
+		}
+		if x._base(p) <= 0 {
+			t.Fatalf("slot %d parent %d base %d", i, p, x._base(p))
+		}
+		code := i - x._base(p)
+		if code < 0 || code > x.MaxCode {
+			t.Fatalf("slot %d bad code %d", i, code)
+		}
+		if c < 0 && x._base(i) > 0 {
+			h := x._base(i)
+			if x._check(h) != i || x._base(h) >= 0 {
+				t.Fatalf("terminal %d holder %d check %d base %d", i, h, x._check(h), x._base(h))
+			}
+		}
+	}
+	checkTree(t, x)
+}
+
+// 子节点链接和Check一致，每个节点正好出现在父节点的链接中一次
+func checkTree(t *testing.T, x *XTrie) {
+	t.Helper()
+	tree := x._tree()
+	if tree == nil {
+		return
+	}
+	nodes, linked := 0, 0
+	for i := 2; i < x.Size; i++ {
+		if x._check(i) != 0 {
+			nodes++
+		}
+	}
+	for p := 1; p < x.Size; p++ {
+		for c := int(tree[p<<1]); c != 0; c = int(tree[c<<1|1]) {
+			if q := x._check(c); q != p && q != -p {
+				t.Fatalf("node %d linked under %d check %d", c, p, q)
+			}
+			if linked++; linked > nodes {
+				t.Fatalf("tree links more than %d nodes", nodes)
+			}
+		}
+	}
+	if linked != nodes {
+		t.Fatalf("tree links %d nodes, want %d", linked, nodes)
+	}
+}
+
+func TestInsert(t *testing.T) {
+	xt := new(XTrie)
+	rnd := rand.New(rand.NewSource(1))
+	alphabet := []rune("ab中国人c")
+	words := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		n := 1 + rnd.Intn(6)
+		key := make([]rune, n)
+		for k := range key {
+			key[k] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		words[string(key)] = i
+		if err := xt.Insert(string(key), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTrie(t, xt)
+	for word, level := range words {
+		if _, got, err := xt.Match(word, false); err != nil || got != level {
+			t.Fatalf("Match(%q) = %d, %v; want %d", word, got, err, level)
+		}
+	}
+	if _, _, err := xt.Match("ab中国人cx", false); err == nil {
+		t.Errorf("Match of missing word should fail")
+	}
+
+	text := "ab中国人cba国人中"
+	var want []MatchResult
+	runes := []rune(text)
+	for i := range runes {
+		for j := i + 1; j <= len(runes); j++ {
+			if level, ok := words[string(runes[i:j])]; ok {
+				want = append(want, MatchResult{string(runes[i:j]), level, len(string(runes[:i])), len(string(runes[:j])), i, j})
+			}
+		}
+	}
+	if got := xt.Search(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("Search = %v; want %v", got, want)
+	}
+
+	//更新已有词的等级同样写入词典，重新读取词典之后保留新的等级
+	path := filepath.Join(t.TempDir(), "dict.txt")
+	if err := os.WriteFile(path, []byte("1 中国\n2 国人"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dt := &XTrie{DictFile: path}
+	if _, err := dt.DictRead(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dt.Insert("中国", 5); err != nil {
+		t.Fatal(err)
+	}
+	reload := &XTrie{DictFile: path}
+	if _, err := reload.DictRead(); err != nil {
+		t.Fatal(err)
+	}
+	if _, level, err := reload.Match("中国", false); err != nil || level != 5 {
+		t.Errorf("level after reload = %d, %v; want 5", level, err)
+	}
+
+	//插入和删除不写store文件，持久化由调用方执行Store
+	store := filepath.Join(t.TempDir(), "dat.data")
+	st := &XTrie{StoreFile: store}
+	if err := st.Insert("中国", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Remove("中国"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store); !os.IsNotExist(err) {
+		t.Errorf("Insert and Remove wrote the store file: %v", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	xt := new(XTrie)
+	for _, word := range []string{"中国", "国人", "ab"} {
+		if err := xt.Insert(word, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := xt.Remove(""); err == nil {
+		t.Errorf("Remove empty key should fail")
+	}
+	array := append([]int32(nil), xt.Array...)
+
+	// 插入再删除之后和没有插入过一样
+	for _, word := range []string{"中国人民", "abc"} {
+		if err := xt.Insert(word, 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := xt.Remove(word); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < xt.Size; i++ {
+			b, c := 0, 0
+			if i*2 < len(array) {
+				b, c = int(array[i*2]), int(array[i*2+1])
+			}
+			if xt._base(i) != b || xt._check(i) != c {
+				t.Fatalf("after removing %q slot %d = %d/%d; want %d/%d", word, i, xt._base(i), xt._check(i), b, c)
+			}
+		}
+	}
+
+	// 随机插入删除，使用的位置数量等于剩余词的节点数量
+	rnd := rand.New(rand.NewSource(2))
+	alphabet := []rune("ab中国人c")
+	words := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		key := make([]rune, 1+rnd.Intn(5))
+		for k := range key {
+			key[k] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		words[string(key)] = true
+		if err := xt.Insert(string(key), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, word := range []string{"中国", "国人", "ab"} {
+		words[word] = true
+	}
+	for word := range words {
+		if rnd.Intn(2) == 0 || word == "中国" || word == "国人" || word == "ab" {
+			continue
+		}
+		if err := xt.Remove(word); err != nil {
+			t.Fatal(err)
+		}
+		delete(words, word)
+	}
+	checkTrie(t, xt)
+
+	nodes := make(map[string]bool)
+	for word := range words {
+		runes := []rune(word)
+		for i := 1; i <= len(runes); i++ {
+			nodes[string(runes[:i])] = true
+		}
+	}
+	want := len(nodes)
+	for word := range words {
+		for other := range words {
+			if other != word && strings.HasPrefix(other, word) {
+				want++ // 有子节点的词结尾需要一个位置存储词id
+				break
+			}
+		}
+	}
+	used := 0
+	for i := 2; i < xt.Size; i++ {
+		if xt._check(i) != 0 {
+			used++
+		}
+	}
+	if used != want {
+		t.Errorf("used slots = %d; want %d", used, want)
+	}
+	for word := range words {
+		if _, _, err := xt.Match(word, false); err != nil {
+			t.Errorf("Match(%q) = %v", word, err)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	xt := new(XTrie)
+	words := make(map[string]int)
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("词%d号", i)
+		words[key] = i
+		if err := xt.Insert(key, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 500; i += 3 {
+		key := fmt.Sprintf("词%d号", i)
+		delete(words, key)
+		if err := xt.Remove(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := xt.Size
+	xt.StoreFile = filepath.Join(t.TempDir(), "dat.data")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if got := xt.Search("词1号"); len(got) != 1 {
+				t.Errorf("Search during compact = %v", got)
+				return
+			}
+		}
+	}()
+	reclaimed, err := xt.Compact()
+	done <- struct{}{}
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed <= 0 || xt.Size >= size {
+		t.Errorf("Compact reclaimed %d bytes, size %d -> %d", reclaimed, size, xt.Size)
+	}
+	if _, err := os.Stat(xt.StoreFile); !os.IsNotExist(err) {
+		t.Errorf("Compact wrote the store file: %v", err)
+	}
+	checkTrie(t, xt)
+	for word, level := range words {
+		if _, got, err := xt.Match(word, false); err != nil || got != level {
+			t.Errorf("Match(%q) = %d, %v; want %d", word, got, err, level)
+		}
+	}
+	if err := xt.Insert("新词", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := xt.Match("新词", false); err != nil {
+		t.Errorf("Match after compact insert = %v", err)
+	}
+}
+
+func TestTail(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	alphabet := []rune("ab中国人c")
+	randWord := func() string {
+		key := make([]rune, 1+rnd.Intn(12))
+		for k := range key {
+			key[k] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		return string(key)
+	}
+	words := make(map[string]int)
+	var lines []string
+	for i := 0; i < 300; i++ {
+		word := randWord()
+		if _, ok := words[word]; !ok {
+			lines = append(lines, fmt.Sprintf("%d %s", i, word))
+			words[word] = i
+		}
+	}
+	xt := newTestTrie(t, &XTrie{TailMode: true}, lines...)
+	if len(xt.Tail) == 0 {
+		t.Fatal("no suffix stored in Tail")
+	}
+
+	for i := 0; i < 600; i++ {
+		word := randWord()
+		if _, ok := words[word]; ok && i%3 == 0 {
+			if err := xt.Remove(word); err != nil {
+				t.Fatal(err)
+			}
+			delete(words, word)
+			continue
+		}
+		words[word] = i
+		if err := xt.Insert(word, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTrie(t, xt)
+	for word, level := range words {
+		if _, got, err := xt.Match(word, false); err != nil || got != level {
+			t.Fatalf("Match(%q) = %d, %v; want %d", word, got, err, level)
+		}
+		if _, _, err := xt.Match(word+"a", false); err == nil {
+			if _, ok := words[word+"a"]; !ok {
+				t.Fatalf("Match(%q) should fail", word+"a")
+			}
+		}
+	}
+
+	//在后缀中间结束的词和普通结构返回一样的等级
+	plain := new(XTrie)
+	for word, level := range words {
+		if err := plain.Insert(word, level); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for word := range words {
+		runes := []rune(word)
+		for n := 1; n < len(runes); n++ {
+			pre := string(runes[:n])
+			for _, force := range []bool{true, false} {
+				_, got, err := xt.Match(pre, force)
+				_, want, wantErr := plain.Match(pre, force)
+				if got != want || (err == nil) != (wantErr == nil) {
+					t.Fatalf("Match(%q, %v) = %d, %v; want %d, %v", pre, force, got, err, want, wantErr)
+				}
+			}
+		}
+	}
+
+	text := []rune("ab中国人cba国人中ab中国人cba国人中")
+	var want []MatchResult
+	for i := range text {
+		for j := i + 1; j <= len(text); j++ {
+			if level, ok := words[string(text[i:j])]; ok {
+				want = append(want, MatchResult{string(text[i:j]), level, len(string(text[:i])), len(string(text[:j])), i, j})
+			}
+		}
+	}
+	if got := xt.Search(string(text)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Search = %v; want %v", got, want)
+	}
+
+	for _, pre := range []string{"a", "中国", "ab中", "c人国a"} {
+		wantSet := make(map[string]int)
+		for word, level := range words {
+			if strings.HasPrefix(word, pre) {
+				wantSet[word] = level
+			}
+		}
+		got, _ := xt.Prefix(pre, len(wantSet))
+		gotSet := make(map[string]int)
+		for _, r := range got {
+			gotSet[r.Word] = r.Level
+		}
+		if !reflect.DeepEqual(gotSet, wantSet) {
+			t.Errorf("Prefix(%q) = %v; want %v", pre, gotSet, wantSet)
+		}
+	}
+	got, _ := xt.Suffix("人c", len(words))
+	count := 0
+	for word := range words {
+		if strings.HasSuffix(word, "人c") {
+			count++
+		}
+	}
+	if len(got) != count {
+		t.Errorf("Suffix = %d words; want %d", len(got), count)
+	}
+}
+
+func TestCodeMap(t *testing.T) {
+	xt := newTestTrie(t, nil, "1 中国", "2 中国人", "3 国人", "4 中华", "5 中文")
+	if xt.MaxCode != 5 || xt.Codes['中'] != 1 || xt.Codes['国'] != 2 {
+		t.Fatalf("MaxCode %d codes %v", xt.MaxCode, xt.Codes)
+	}
+	if xt.Size > 64 {
+		t.Errorf("Size = %d; want a compact structure", xt.Size)
+	}
+	snap := xt.Snapshot()
+	if err := xt.Insert("龘国", 6); err != nil {
+		t.Fatal(err)
+	}
+	checkTrie(t, xt)
+	if _, level, err := xt.Match("龘国", false); err != nil || level != 6 {
+		t.Errorf("Match = %d, %v", level, err)
+	}
+	if _, _, err := snap.Match("龘国", false); err == nil {
+		t.Errorf("snapshot should not see the new word")
+	}
+	if got := xt.Search("我是龘国中国人"); len(got) != 4 {
+		t.Errorf("Search = %v", got)
+	}
+	if got, _ := xt.Suffix("国", 10); len(got) != 2 {
+		t.Errorf("Suffix = %v", got)
+	}
+}
+
+func TestByteMode(t *testing.T) {
+	lines := []string{"1 中国", "2 中国人", "3 国人", "4 abc", "5 ab", "6 a中b", "7 http://example.com/path"}
+	rt := newTestTrie(t, nil, lines...)
+	bt := newTestTrie(t, &XTrie{ByteMode: true, TailMode: true}, lines...)
+	if bt.MaxCode > 255 || bt.Codes != nil {
+		t.Fatalf("MaxCode %d", bt.MaxCode)
+	}
+	for _, xt := range []*XTrie{rt, bt} {
+		if err := xt.Insert("人民", 8); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTrie(t, bt)
+
+	text := "我是中国人民，ab中b a中b，访问http://example.com/path"
+	want := rt.Search(text)
+	if got := bt.Search(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("Search = %v; want %v", got, want)
+	}
+	for _, r := range want {
+		if text[r.Start:r.End] != r.Word {
+			t.Errorf("%v does not match the text", r)
+		}
+	}
+	for _, key := range []string{"中国", "中国人", "人民", "a中b", "http://example.com/path"} {
+		if _, level, err := bt.Match(key, false); err != nil || level == 0 {
+			t.Errorf("Match(%q) = %d, %v", key, level, err)
+		}
+	}
+	if _, _, err := bt.Match("中", false); err == nil {
+		t.Errorf("Match(中) should not be found")
+	}
+	if got, _ := bt.Prefix("中", 10); len(got) != 2 || got[1].Word != "中国人" {
+		t.Errorf("Prefix = %v", got)
+	}
+	if got, _ := bt.Suffix("b", 10); len(got) != 2 {
+		t.Errorf("Suffix = %v", got)
+	}
+	if err := bt.Remove("中国人"); err != nil {
+		t.Fatal(err)
+	}
+	checkTrie(t, bt)
+	if _, _, err := bt.Match("中国", false); err != nil {
+		t.Errorf("Match(中国) = %v", err)
+	}
+}
+
+// 按照Zipf分布生成n个中文词，常用字出现的次数更多
+func chineseWords(n int) map[string]int {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.1, 2, 6000)
+	words := make(map[string]int, n)
+	for len(words) < n {
+		key := make([]rune, 2+rnd.Intn(3))
+		for k := range key {
+			key[k] = rune(0x4E00 + zipf.Uint64())
+		}
+		words[string(key)] = rnd.Intn(10)
+	}
+	return words
+}
+
+func BenchmarkBuild(b *testing.B) {
+	xt := new(XTrie)
+	for word, level := range chineseWords(1000000) {
+		xt._setLevel(word, level)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := xt._fork().build(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	benchmarkInsert(b, new(XTrie))
+}
+
+// AC自动机模式插入时只更新受影响节点的链接
+func BenchmarkInsertAC(b *testing.B) {
+	benchmarkInsert(b, &XTrie{ACMode: true})
+}
+
+func benchmarkInsert(b *testing.B, xt *XTrie) {
+	for word, level := range chineseWords(100000) {
+		xt._setLevel(word, level)
+	}
+	if err := xt._rebuild(xt._fork()); err != nil {
+		b.Fatal(err)
+	}
+	words := make([]string, 0, b.N)
+	for word := range chineseWords(100000 + b.N) {
+		if _, ok := xt.Keymap[word]; !ok {
+			words = append(words, word)
+		}
+	}
+	b.ResetTimer()
+	for _, word := range words {
+		if err := xt.Insert(word, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 增量更新的链接和重新计算的链接一致
+func checkLinks(t *testing.T, x *XTrie) {
+	t.Helper()
+	if !x._linked() {
+		t.Fatal("links are not up to date")
+	}
+	l, want := x.links, x._links()
+	for i := 1; i < x.Size; i++ {
+		if l.fail[i] != want.fail[i] || l.out[i] != want.out[i] || l.depth[i] != want.depth[i] {
+			t.Fatalf("node %d links %d %d %d; want %d %d %d", i, l.fail[i], l.out[i], l.depth[i], want.fail[i], want.out[i], want.depth[i])
+		}
+		for c := l.head[i]; c != 0; c = l.next[c] {
+			if int(l.fail[c]) != i || (l.next[c] != 0 && l.prev[l.next[c]] != c) {
+				t.Fatalf("node %d bad reverse link %d", i, c)
+			}
+		}
+	}
+	if l.max < want.max {
+		t.Fatalf("max depth %d; want at least %d", l.max, want.max)
+	}
+}
+
+func TestAC(t *testing.T) {
+	rnd := rand.New(rand.NewSource(5))
+	alphabet := []rune("ab中国人c")
+	randWord := func(n int) string {
+		key := make([]rune, 1+rnd.Intn(n))
+		for k := range key {
+			key[k] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		return string(key)
+	}
+	xt := &XTrie{ACMode: true, TailMode: true}
+	words := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		word := randWord(5)
+		words[word] = i
+		xt._setLevel(word, i)
+	}
+	if err := xt._rebuild(xt._fork()); err != nil {
+		t.Fatal(err)
+	}
+	search := func(text string) []MatchResult {
+		var want []MatchResult
+		runes := []rune(text)
+		for i := range runes {
+			for j := i + 1; j <= len(runes); j++ {
+				if level, ok := words[string(runes[i:j])]; ok {
+					want = append(want, MatchResult{string(runes[i:j]), level, len(string(runes[:i])), len(string(runes[:j])), i, j})
+				}
+			}
+		}
+		return want
+	}
+	check := func() {
+		t.Helper()
+		if !xt._linked() {
+			t.Fatal("links are not up to date")
+		}
+		for i := 0; i < 20; i++ {
+			text := randWord(30) + "x" + randWord(30)
+			if got, want := xt.Search(text), search(text); !reflect.DeepEqual(got, want) {
+				t.Fatalf("Search(%q) = %v; want %v", text, got, want)
+			}
+		}
+	}
+	check()
+	snap := xt.Snapshot()
+	text := randWord(30)
+	before := xt.Search(text)
+	var links *acLinks
+	for i := 0; i < 200; i++ {
+		word := randWord(6)
+		if _, ok := words[word]; ok {
+			if err := xt.Remove(word); err != nil {
+				t.Fatal(err)
+			}
+			delete(words, word)
+		} else {
+			words[word] = i
+			if err := xt.Insert(word, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkLinks(t, xt)
+		if i == 0 { //第一次修改复制快照共享的链接，之后原地更新
+			links = xt.links
+		} else if xt.links != links {
+			t.Fatal("links are recomputed")
+		}
+	}
+	checkTrie(t, xt)
+	check()
+	if got := snap.Search(text); !reflect.DeepEqual(got, before) {
+		t.Errorf("snapshot Search = %v; want %v", got, before)
+	}
+}
+
+func TestMatchMode(t *testing.T) {
+	lines := []string{"1 中国", "2 中国人", "3 国人", "4 人民", "5 中"}
+	ac := newTestTrie(t, &XTrie{ACMode: true}, lines...)
+	text := "我是中国人民"
+	want := map[MatchMode][]MatchResult{
+		MatchAll: {{"中", 5, 6, 9, 2, 3}, {"中国", 1, 6, 12, 2, 4}, {"中国人", 2, 6, 15, 2, 5}, {"国人", 3, 9, 15, 3, 5}, {"人民", 4, 12, 18, 4, 6}},
+		MatchLeftmostLongest: {{"中国人", 2, 6, 15, 2, 5}},
+		MatchLeftmostFirst:   {{"中国", 1, 6, 12, 2, 4}, {"人民", 4, 12, 18, 4, 6}},
+		MatchShortest:        {{"中", 5, 6, 9, 2, 3}, {"国人", 3, 9, 15, 3, 5}},
+	}
+	for _, xt := range []*XTrie{newTestTrie(t, nil, lines...), ac} {
+		for mode, w := range want {
+			if got := xt.Search(text, WithMode(mode)); !reflect.DeepEqual(got, w) {
+				t.Errorf("ACMode %v mode %d Search = %v; want %v", xt.ACMode, mode, got, w)
+			}
+		}
+	}
+}
+
+func TestSegment(t *testing.T) {
+	xt := newTestTrie(t, nil, "1 研究", "2 研究生", "3 生命", "4 命", "5 起源")
+	words := func(tokens []Token) string {
+		var parts []string
+		for _, token := range tokens {
+			if !token.Known {
+				parts = append(parts, "?"+token.Word)
+				continue
+			}
+			parts = append(parts, token.Word)
+		}
+		return strings.Join(parts, "/")
+	}
+	text := "我们研究生命起源xy"
+	for mode, want := range map[SegmentMode]string{
+		SegmentForward:       "?我们/研究生/命/起源/?xy",
+		SegmentBackward:      "?我们/研究/生命/起源/?xy",
+		SegmentBidirectional: "?我们/研究/生命/起源/?xy",
+	} {
+		tokens := xt.Segment(text, mode)
+		if got := words(tokens); got != want {
+			t.Errorf("mode %d Segment = %s; want %s", mode, got, want)
+		}
+		end := 0
+		for _, token := range tokens {
+			if token.Start != end || text[token.Start:token.End] != token.Word || string([]rune(text)[token.RuneStart:token.RuneEnd]) != token.Word {
+				t.Fatalf("mode %d token %v does not cover the text", mode, token)
+			}
+			end = token.End
+		}
+		if end != len(text) {
+			t.Errorf("mode %d tokens end at %d", mode, end)
+		}
+	}
+	if tokens := xt.Segment("研究生", SegmentBidirectional); len(tokens) != 1 || tokens[0].Level != 2 {
+		t.Errorf("Segment = %v", tokens)
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	xt := newTestTrie(t, nil, "1 中国", "2 ab")
+	text := "中国ab中国x中"
+	want := []MatchResult{{"中国", 1, 0, 6, 0, 2}, {"ab", 2, 6, 8, 2, 4}, {"中国", 1, 8, 14, 4, 6}}
+	if got := xt.Search(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("Search = %v; want %v", got, want)
+	}
+	got, err := xt.Fuzzy(text, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runes := []rune(text)
+	count := map[string]int{}
+	for _, r := range got {
+		hit := text[r.Start:r.End]
+		if string(runes[r.RuneStart:r.RuneEnd]) != hit || !strings.Contains(r.Word, hit) {
+			t.Errorf("Fuzzy hit %v does not match the text", r)
+		}
+		count[r.Word]++
+	}
+	//中国的字符在内容中出现5次，ab的字符出现2次
+	if count["中国"] != 5 || count["ab"] != 2 {
+		t.Errorf("Fuzzy = %v", got)
+	}
+	if got, _ := xt.Fuzzy(text, 3); len(got) != 3 {
+		t.Errorf("Fuzzy limit = %v", got)
+	}
+	//无效的字节只占用一个字节
+	nt := &XTrie{Normalizer: FoldASCII}
+	nt._setLevel("x\uFFFD", 1)
+	if err := nt._rebuild(nt._fork()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := nt.Fuzzy("Z\xff", 10); len(got) != 1 || got[0].Start != 1 || got[0].End != 2 {
+		t.Errorf("Fuzzy invalid byte = %v", got)
+	}
+}
+
+func TestMask(t *testing.T) {
+	xt := newTestTrie(t, nil, "1 中国", "2 中国人", "3 国人", "9 坏蛋", "2 ab")
+	text := "我是中国人，你是坏蛋ab"
+	for _, c := range []struct {
+		opts []MaskOption
+		want string
+	}{
+		{nil, "我是***，你是****"},
+		{[]MaskOption{WithMinLevel(3)}, "我是中**，你是**ab"},
+		{[]MaskOption{WithKeep(true, true)}, "我是中*人，你是坏*a*"},
+		{[]MaskOption{WithKeep(false, true)}, "我是**人，你是*蛋*b"},
+		{[]MaskOption{WithReplacements(map[string]string{"坏蛋": "好人", "国人": "-"}), WithMinLevel(3)}, "我是中-，你是好人ab"},
+		{[]MaskOption{WithReplacements(map[string]string{"中国人": "X", "中国": "Y"})}, "我是X，你是****"},
+	} {
+		if got := xt.Mask(text, '*', c.opts...); got != c.want {
+			t.Errorf("Mask = %s; want %s", got, c.want)
+		}
+	}
+	got := xt.Replace(text, func(r MatchResult) string {
+		if r.Level < 5 {
+			return r.Word
+		}
+		return "<" + r.Word + ">"
+	})
+	if want := "我是中国人，你是<坏蛋>ab"; got != want {
+		t.Errorf("Replace = %s; want %s", got, want)
+	}
+	if got := xt.Replace("中国人", func(r MatchResult) string { return "[" + r.Word + "]" }); got != "[中国人]" {
+		t.Errorf("Replace overlapping = %s", got)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	lines := []string{"1 敏感", "2 fuck", "3 中国人", "4 中国"}
+	tail := newTestTrie(t, &XTrie{TailMode: true}, lines...)
+	ac := newTestTrie(t, &XTrie{ACMode: true}, lines...)
+	text := "说敏*感词f.u.c.k，中\u200b国 人"
+	for _, xt := range []*XTrie{newTestTrie(t, nil, lines...), tail, ac} {
+		got := xt.Search(text, WithSkip(SkipSet{Categories: []*unicode.RangeTable{unicode.P, unicode.Z, unicode.Cf}, Runes: []rune{'*'}}))
+		want := []string{"敏感", "fuck", "中国", "中国人"}
+		if len(got) != len(want) {
+			t.Fatalf("Search = %v; want %v", got, want)
+		}
+		runes := []rune(text)
+		for i, r := range got {
+			if r.Word != want[i] {
+				t.Errorf("Search[%d] = %v; want %s", i, r, want[i])
+			}
+			//范围覆盖原文，第一个和最后一个字符是词的字符
+			span := []rune(text[r.Start:r.End])
+			words := []rune(r.Word)
+			if span[0] != words[0] || span[len(span)-1] != words[len(words)-1] || string(runes[r.RuneStart:r.RuneEnd]) != string(span) {
+				t.Errorf("Search[%d] span %q does not cover %s", i, string(span), r.Word)
+			}
+		}
+		if got := xt.Search(text); len(got) != 0 {
+			t.Errorf("Search without skip = %v", got)
+		}
+		//最多跳过一个字符
+		gap := SkipSet{MaxGap: 1}
+		if got := xt.Search("敏**感敏x感", WithSkip(gap)); len(got) != 1 || got[0].Start != len("敏**感") {
+			t.Errorf("Search with gap = %v", got)
+		}
+		if got := xt.Search("中国人", WithSkip(gap), WithMode(MatchLeftmostLongest)); len(got) != 1 || got[0].Word != "中国人" {
+			t.Errorf("Search longest with gap = %v", got)
+		}
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	xt := newTestTrie(t, &XTrie{Normalizer: Normalizers(FoldWidth, FoldASCII, FoldTraditional)}, "1 ABC", "2 中國", "3 国人", "4 ｘｙ")
+	for word, want := range map[string]int{"abc": 1, "ＡbＣ": 1, "中国": 2, "中國": 2, "國人": 3, "XY": 4} {
+		if _, level, err := xt.Match(word, false); err != nil || level != want {
+			t.Errorf("Match(%q) = %d, %v; want %d", word, level, err, want)
+		}
+	}
+	if err := xt.Insert("Ｄef", 5); err != nil {
+		t.Fatal(err)
+	}
+	text := "我是中國人，ＡＢＣ和Def"
+	want := []MatchResult{{"中国", 2, 6, 12, 2, 4}, {"国人", 3, 9, 15, 3, 5}, {"abc", 1, 18, 27, 6, 9}, {"def", 5, 30, 33, 10, 13}}
+	got := xt.Search(text)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Search = %v; want %v", got, want)
+	}
+	if got := xt.Mask(text, '*'); got != "我是***，***和***" {
+		t.Errorf("Mask = %s", got)
+	}
+	if got, err := xt.Prefix("中國", 1); err != nil || len(got) != 1 || got[0].Word != "中国" {
+		t.Errorf("Prefix = %v, %v", got, err)
+	}
+	if got, err := xt.Fuzzy("ＡＢ", 10); err != nil || len(got) != 2 || got[1].Start != 3 || got[1].End != 6 {
+		t.Errorf("Fuzzy = %v, %v", got, err)
+	}
+	if err := xt.Remove("DEF"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := xt.Match("def", false); err == nil {
+		t.Errorf("Match after Remove should fail")
+	}
+
+	//词典文件中规范化之后相同的行一起删除
+	path := filepath.Join(t.TempDir(), "dict.txt")
+	if err := os.WriteFile(path, []byte("1 ABC\n2 xyz\n3 abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dt := &XTrie{DictFile: path, Normalizer: FoldASCII}
+	if _, err := dt.DictRead(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dt.Remove("Abc"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "2 xyz\n" {
+		t.Errorf("dict after Remove = %q", data)
+	}
+	//词典文件中写入调用方传入的词
+	if err := dt.Insert("AbC", 4); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !strings.HasSuffix(string(data), "4 AbC") {
+		t.Errorf("dict after Insert = %q", data)
+	}
+	if got := dt.Replace("xABCy", func(r MatchResult) string { return "xABCy"[r.Start:r.End] }); got != "xABCy" {
+		t.Errorf("Replace = %q", got)
+	}
+
+	//值和规范化之后的词对应
+	vt := NewValueTrie[string]()
+	vt.Normalizer = FoldASCII
+	vt.Add("ABC", 1, "first")
+	if err := vt.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vt.Insert("QQQ", 3, "third"); err != nil {
+		t.Fatal(err)
+	}
+	for word, want := range map[string]string{"abc": "first", "qqq": "third", "Qqq": "third"} {
+		if v, _, err := vt.Match(word); err != nil || v != want {
+			t.Errorf("ValueTrie Match(%q) = %q, %v; want %q", word, v, err, want)
+		}
+	}
+	if err := vt.Remove("QqQ"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := vt.Match("qqq"); err == nil {
+		t.Errorf("ValueTrie Match after Remove should fail")
+	}
+}
+
+// 每次只读取一个字节
+type oneByteReader struct {
+	s string
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(r.s) == 0 {
+		return 0, io.EOF
+	}
+	p[0] = r.s[0]
+	r.s = r.s[1:]
+	return 1, nil
+}
+
+func TestSearchReader(t *testing.T) {
+	lines := []string{"1 中国", "2 中国人", "3 国人", "4 人民", "5 中华人民共和国", "6 ab"}
+	ac := newTestTrie(t, &XTrie{ACMode: true, Normalizer: FoldASCII}, lines...)
+	text := strings.Repeat("我是中国人民，中华人民共和国ab，", 50)
+	for _, xt := range []*XTrie{newTestTrie(t, nil, lines...), ac} {
+		for _, mode := range []MatchMode{MatchAll, MatchLeftmostLongest, MatchShortest} {
+			want := xt.Search(text, WithMode(mode))
+			for _, chunk := range []int{1, 3, 7, 100, 0} {
+				var got []MatchResult
+				size := func(o *searchOptions) { o.chunk = chunk }
+				err := xt.SearchReader(strings.NewReader(text), func(r MatchResult) bool {
+					got = append(got, r)
+					return true
+				}, WithMode(mode), size)
+				if err != nil || !reflect.DeepEqual(got, want) {
+					t.Fatalf("mode %d chunk %d SearchReader = %d results, %v; want %d", mode, chunk, len(got), err, len(want))
+				}
+			}
+		}
+		var got []MatchResult
+		err := xt.Snapshot().SearchReader(&oneByteReader{text}, func(r MatchResult) bool {
+			got = append(got, r)
+			return len(got) < 10
+		})
+		if want := xt.Search(text)[:10]; err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Snapshot SearchReader = %v, %v; want %v", got, err, want)
+		}
+	}
+
+	//最长词的字符数随插入更新，加载时重新计算
+	xt := newTestTrie(t, nil, lines...)
+	if err := xt.Insert("很长很长的一个词", 7); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dat.data")
+	if err := xt.Store(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := new(XTrie)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []*XTrie{xt, loaded} {
+		if window := x._window(&searchOptions{}); window != 8 {
+			t.Errorf("window = %d; want 8", window)
+		}
+	}
+}
+
+func TestSearchFunc(t *testing.T) {
+	lines := []string{"1 中国", "2 中国人", "3 国人", "4 人民", "5 中华", "6 中华人民共和国"}
+	tail := newTestTrie(t, &XTrie{TailMode: true}, lines...)
+	ac := newTestTrie(t, &XTrie{ACMode: true}, lines...)
+	plain := newTestTrie(t, nil, lines...)
+	for _, xt := range []*XTrie{plain, tail, ac} {
+		text := strings.Repeat("我是中国人民，中华人民共和国，", 20)
+		for _, mode := range []MatchMode{MatchAll, MatchLeftmostLongest, MatchLeftmostFirst, MatchShortest} {
+			var got []MatchResult
+			for r := range xt.SearchSeq(text, WithMode(mode)) {
+				got = append(got, r)
+			}
+			//AC自动机回调的顺序和逐字查找一致
+			if want := plain.Search(text, WithMode(mode)); !reflect.DeepEqual(got, want) {
+				t.Errorf("mode %d SearchSeq = %v; want %v", mode, got, want)
+			}
+		}
+		var got []MatchResult
+		for r := range xt.Snapshot().SearchSeq(text) {
+			if len(got) == 3 {
+				break
+			}
+			got = append(got, r)
+		}
+		if want := xt.Search(text)[:3]; !reflect.DeepEqual(got, want) {
+			t.Errorf("SearchSeq break = %v; want %v", got, want)
+		}
+		//回调的Word可以直接保留，slices.Collect的结果和Prefix、Suffix、Fuzzy一致
+		for pre, want := range map[string]int{"中": 4, "中华": 2, "中华人": 1, "人": 1, "国": 1} {
+			prefix, _ := xt.Prefix(pre, want)
+			if got := slices.Collect(xt.PrefixSeq(pre)); len(got) != want || !reflect.DeepEqual(got, prefix) {
+				t.Errorf("PrefixSeq(%s) = %v; want %v", pre, got, prefix)
+			}
+		}
+		suffix, _ := xt.Suffix("人", 10)
+		if got := slices.Collect(xt.SuffixSeq("人")); len(got) != 2 || !reflect.DeepEqual(got, suffix) {
+			t.Errorf("SuffixSeq = %v; want %v", got, suffix)
+		}
+		fuzzy, _ := xt.Fuzzy(text, 1000)
+		if got := slices.Collect(xt.FuzzySeq(text)); !reflect.DeepEqual(got, fuzzy) {
+			t.Errorf("FuzzySeq = %d results; want %d", len(got), len(fuzzy))
+		}
+
+		//SearchFunc的内存分配次数和命中的词数量无关，FuzzyFunc每个命中的词分配一次
+		count := 0
+		fn := func(r MatchResult) bool {
+			count++
+			return true
+		}
+		long := strings.Repeat(text, 10)
+		for name, f := range map[string]func(string){
+			"SearchFunc": func(s string) { xt.SearchFunc(s, fn) },
+			"FuzzyFunc":  func(s string) { xt.FuzzyFunc(s, fn) },
+		} {
+			short := testing.AllocsPerRun(10, func() { f(text) })
+			if allocs := testing.AllocsPerRun(10, func() { f(long) }); allocs != short || (name == "SearchFunc" && allocs > 4) {
+				t.Errorf("%s allocs = %v, %v", name, short, allocs)
+			}
+		}
+		//PrefixFunc、SuffixFunc每个结果分配一个字符串
+		if allocs := testing.AllocsPerRun(10, func() { xt.PrefixFunc("中", fn) }); allocs > 2+4 {
+			t.Errorf("PrefixFunc allocs = %v", allocs)
+		}
+		if allocs := testing.AllocsPerRun(10, func() { xt.SuffixFunc("人", fn) }); allocs > 2+2 {
+			t.Errorf("SuffixFunc allocs = %v", allocs)
+		}
+		if count == 0 {
+			t.Errorf("no results")
+		}
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	lines := []string{"1 中国", "7 中国人", "3 国人", "9 人民", "5 中华", "8 中华人民共和国"}
+	ac := newTestTrie(t, &XTrie{ACMode: true}, lines...)
+	levels := func(result []MatchResult) []int {
+		var l []int
+		for _, r := range result {
+			l = append(l, r.Level)
+		}
+		return l
+	}
+	text := "我是中国人民，中华人民共和国"
+	for _, xt := range []*XTrie{newTestTrie(t, nil, lines...), ac} {
+		if got := levels(xt.Search(text, WithLevels(7, 100))); !reflect.DeepEqual(got, []int{7, 9, 8, 9}) {
+			t.Errorf("ACMode %v Search = %v", xt.ACMode, got)
+		}
+		if got := levels(xt.Search(text, WithLevels(3, 6), WithLevelSet(1, 3, 9))); !reflect.DeepEqual(got, []int{3}) {
+			t.Errorf("ACMode %v Search = %v", xt.ACMode, got)
+		}
+		//等级不满足条件的词不参与选择
+		if got := xt.Search(text, WithMode(MatchLeftmostLongest), WithLevelSet(1, 5)); len(got) != 2 || got[0].Word != "中国" || got[1].Word != "中华" {
+			t.Errorf("ACMode %v Search longest = %v", xt.ACMode, got)
+		}
+		if got, _ := xt.Prefix("中", 10, WithLevels(6, 8)); !reflect.DeepEqual(levels(got), []int{8, 7}) {
+			t.Errorf("Prefix = %v", got)
+		}
+		if got, _ := xt.Suffix("人", 10, WithLevelSet(3)); len(got) != 1 || got[0].Word != "国人" {
+			t.Errorf("Suffix = %v", got)
+		}
+		if got, _ := xt.Fuzzy("国", 10, WithLevels(7, 7)); len(got) != 1 || got[0].Word != "中国人" {
+			t.Errorf("Fuzzy = %v", got)
+		}
+		count := 0
+		xt.PrefixFunc("中", func(r MatchResult) bool {
+			count++
+			return true
+		}, WithLevelSet(100))
+		if count != 0 {
+			t.Errorf("PrefixFunc count = %d", count)
+		}
+	}
+}
+
+func TestCommonPrefixes(t *testing.T) {
+	lines := []string{"1 /api", "2 /api/v1", "3 /api/v1/users", "4 /app", "5 中国", "6 中国人民"}
+	tail := newTestTrie(t, &XTrie{TailMode: true, Normalizer: FoldWidth}, lines...)
+	for _, xt := range []*XTrie{newTestTrie(t, nil, lines...), tail} {
+		got := xt.CommonPrefixes("/api/v1/users/42")
+		want := []MatchResult{{"/api", 1, 0, 4, 0, 4}, {"/api/v1", 2, 0, 7, 0, 7}, {"/api/v1/users", 3, 0, 13, 0, 13}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("CommonPrefixes = %v; want %v", got, want)
+		}
+		if got := xt.Snapshot().CommonPrefixes("/api/v1/users/42", WithLevels(2, 2)); !reflect.DeepEqual(got, want[1:2]) {
+			t.Errorf("CommonPrefixes with level = %v", got)
+		}
+		if got, ok := xt.LongestPrefix("/api/v2"); !ok || got != want[0] {
+			t.Errorf("LongestPrefix = %v, %v", got, ok)
+		}
+		if got, ok := xt.LongestPrefix("中国人民共和国"); !ok || got.Word != "中国人民" || got.End != 12 || got.RuneEnd != 4 || got.Level != 6 {
+			t.Errorf("LongestPrefix = %v, %v", got, ok)
+		}
+		if got, ok := xt.LongestPrefix("/ap"); ok {
+			t.Errorf("LongestPrefix = %v, %v", got, ok)
+		}
+	}
+	//全角字符规范化之后的长度对应原始内容
+	if got, ok := tail.LongestPrefix("／ａｐｉ/v1x"); !ok || got.Word != "/api/v1" || got.End != 15 || got.RuneEnd != 7 {
+		t.Errorf("LongestPrefix normalized = %v, %v", got, ok)
+	}
+}
+
+func TestPrefixOrder(t *testing.T) {
+	lines := []string{"3 中国", "1 中华", "5 中国人", "5 中文", "2 中华人民共和国", "4 中", "9 中山", "7 美国"}
+	tail := newTestTrie(t, &XTrie{TailMode: true}, lines...)
+	words := func(result []MatchResult) string {
+		var w []string
+		for _, r := range result {
+			w = append(w, r.Word)
+		}
+		return strings.Join(w, ",")
+	}
+	lexical := "中,中华,中华人民共和国,中国,中国人,中山,中文"
+	for _, xt := range []*XTrie{newTestTrie(t, nil, lines...), tail} {
+		got, total, err := xt.PrefixPage("中", 100)
+		if err != nil || words(got) != lexical || total != 7 {
+			t.Errorf("PrefixPage = %s, %d, %v", words(got), total, err)
+		}
+		//分页的结果和一次取出所有结果之后切分一致
+		all := strings.Split(lexical, ",")
+		for offset := 0; offset <= 8; offset++ {
+			for limit := 0; limit <= 3; limit++ {
+				got, total, _ := xt.PrefixPage("中", limit, WithOffset(offset))
+				want := strings.Join(all[min(offset, 7):min(offset+limit, 7)], ",")
+				if words(got) != want || total != 7 || len(got) > limit {
+					t.Errorf("offset %d limit %d PrefixPage = %s, %d; want %s", offset, limit, words(got), total, want)
+				}
+			}
+		}
+		if got, _ := xt.Prefix("中", 4, WithOrder(OrderLevel)); words(got) != "中山,中国人,中文,中" {
+			t.Errorf("Prefix by level = %s", words(got))
+		}
+		if got, total, _ := xt.PrefixPage("中", 2, WithOrder(OrderLevel), WithOffset(3), WithLevels(2, 5)); words(got) != "中国,中华人民共和国" || total != 5 {
+			t.Errorf("PrefixPage by level = %s, %d", words(got), total)
+		}
+		weight := func(r MatchResult) float64 {
+			return float64(utf8.RuneCountInString(r.Word))
+		}
+		if got, _ := xt.Snapshot().Prefix("中", 3, WithWeight(weight)); words(got) != "中华人民共和国,中国人,中华" {
+			t.Errorf("Prefix by weight = %s", words(got))
+		}
+		if seq := slices.Collect(xt.PrefixSeq("中")); words(seq) != lexical {
+			t.Errorf("PrefixSeq = %s", words(seq))
+		}
+	}
+}
+
+func TestPrefixTree(t *testing.T) {
+	xt := new(XTrie)
+	rnd := rand.New(rand.NewSource(7))
+	alphabet := []rune("ab中国人c")
+	var keys []string
+	for i := 0; i < 3000; i++ {
+		if i == 100 {
+			xt.Prefix("a", 1) //第一次前缀检索生成子节点链接，之后随插入和删除维护
+			if xt._tree() == nil {
+				t.Fatal("Prefix did not build tree links")
+			}
+		}
+		if i%500 == 0 {
+			xt.Snapshot() //快照之后的修改使用复制的子节点链接
+		}
+		if len(keys) > 0 && rnd.Intn(3) == 0 {
+			k := rnd.Intn(len(keys))
+			xt.Remove(keys[k])
+			keys = append(keys[:k], keys[k+1:]...)
+			continue
+		}
+		key := make([]rune, 1+rnd.Intn(6))
+		for k := range key {
+			key[k] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		if _, ok := xt.Keymap[string(key)]; !ok && xt.Insert(string(key), 1+rnd.Intn(9)) == nil {
+			keys = append(keys, string(key))
+		}
+	}
+	if xt._tree() == nil {
+		t.Fatal("tree links are not maintained")
+	}
+	checkTrie(t, xt)
+	if _, err := xt.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	checkTrie(t, xt)
+	path := filepath.Join(t.TempDir(), "tree.data")
+	if err := xt.Store(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := new(XTrie)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded._tree() != nil {
+		t.Fatal("tree links should be built on the first Prefix")
+	}
+	slices.Sort(keys)
+	for _, pre := range []string{"a", "中", "中国", "b人"} {
+		got, total, err := loaded.PrefixPage(pre, 1000)
+		var want []string
+		for _, key := range keys {
+			if strings.HasPrefix(key, pre) {
+				want = append(want, key)
+			}
+		}
+		words := make([]string, len(got))
+		for k, r := range got {
+			words[k] = r.Word
+		}
+		if err != nil || total != len(want) || !slices.Equal(words, want) {
+			t.Errorf("PrefixPage(%q) = %v, total %d; want %v", pre, words, total, want)
+		}
+	}
+	checkTrie(t, loaded)
+}
@@ -0,0 +1,139 @@
+// 敏感词过滤
+// Mask把内容中命中的词替换为掩码字符，相互重叠的词合并为一段连续的区间一起处理
+// Replace按照从左到右最长匹配选出不重叠的词，由调用方决定每个词替换成什么
+
+package xtrie
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// 掩码选项
+type MaskOption func(*maskOptions)
+
+type maskOptions struct {
+	minLevel     int
+	hasMin       bool
+	keepFirst    bool
+	keepLast     bool
+	replacements map[string]string
+}
+
+// 只处理等级大于等于level的词，默认处理所有的词
+func WithMinLevel(level int) MaskOption {
+	return func(o *maskOptions) {
+		o.minLevel = level
+		o.hasMin = true
+	}
+}
+
+// 保留区间的第一个和最后一个字符
+// 区间至少掩码一个字符，两个字符的区间同时保留时只保留第一个字符，一个字符的区间全部掩码
+func WithKeep(first, last bool) MaskOption {
+	return func(o *maskOptions) {
+		o.keepFirst = first
+		o.keepLast = last
+	}
+}
+
+// 设置词的替换字符串，区间正好是这个词时使用替换字符串，不使用掩码字符
+func WithReplacements(replacements map[string]string) MaskOption {
+	return func(o *maskOptions) {
+		if o.replacements == nil {
+			o.replacements = make(map[string]string, len(replacements))
+		}
+		for word, replacement := range replacements {
+			o.replacements[word] = replacement
+		}
+	}
+}
+
+// 把内容中命中的词替换为掩码字符
+// 参数 text string 内容，mask rune 掩码字符，每个字符替换为一个掩码字符
+func (x *XTrie) Mask(text string, mask rune, opts ...MaskOption) string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x._mask(text, mask, opts)
+}
+
+// 按照从左到右最长匹配替换内容中的词
+// 参数 fn func(MatchResult) string 返回词的替换字符串，fn中不能修改词库
+// 设置了Normalizer时r.Word是规范化之后的词，保持原文不变时返回text[r.Start:r.End]
+func (x *XTrie) Replace(text string, fn func(MatchResult) string) string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x._replace(text, fn)
+}
+
+func (x *XTrie) _mask(text string, mask rune, opts []MaskOption) string {
+	var o maskOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var so searchOptions
+	matches := x._search(text, &so)
+	var sb strings.Builder
+	sb.Grow(len(text))
+	pos := 0
+	for i := 0; i < len(matches); {
+		if o.hasMin && matches[i].Level < o.minLevel {
+			i++
+			continue
+		}
+		//合并相互重叠的词，区间正好是某个词时记录这个词
+		start, end := matches[i].Start, matches[i].End
+		word, exact := matches[i].Word, true
+		for i++; i < len(matches) && matches[i].Start < end; i++ {
+			if o.hasMin && matches[i].Level < o.minLevel {
+				continue
+			}
+			if matches[i].End > end {
+				end, exact = matches[i].End, false
+			}
+			if matches[i].Start == start && matches[i].End == end {
+				word, exact = matches[i].Word, true
+			}
+		}
+		sb.WriteString(text[pos:start])
+		pos = end
+		if replacement, ok := o.replacements[word]; ok && exact {
+			sb.WriteString(replacement)
+			continue
+		}
+		o._mask(&sb, text[start:end], mask)
+	}
+	sb.WriteString(text[pos:])
+	return sb.String()
+}
+
+// 区间的每个字符替换为掩码字符，根据选项保留第一个和最后一个字符
+func (o *maskOptions) _mask(sb *strings.Builder, span string, mask rune) {
+	count := utf8.RuneCountInString(span)
+	first := o.keepFirst && count > 1
+	last := o.keepLast && count > 1 && !(first && count == 2)
+	k := 0
+	for _, r := range span {
+		if (k == 0 && first) || (k == count-1 && last) {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune(mask)
+		}
+		k++
+	}
+}
+
+func (x *XTrie) _replace(text string, fn func(MatchResult) string) string {
+	o := searchOptions{mode: MatchLeftmostLongest}
+	matches := x._search(text, &o)
+	var sb strings.Builder
+	sb.Grow(len(text))
+	pos := 0
+	for _, r := range matches {
+		sb.WriteString(text[pos:r.Start])
+		sb.WriteString(fn(r))
+		pos = r.End
+	}
+	sb.WriteString(text[pos:])
+	return sb.String()
+}
@@ -27,7 +27,7 @@ func (n *Node) fetch (xt *XTrie) []*Node {
 		}
 		pre = xt.Keys[i][n.Depth]
 		newNode := new(Node)
-		newNode.Code  = int(xt.Keys[i][n.Depth])
+		newNode.Code  = xt._code(xt.Keys[i][n.Depth])
 		newNode.Depth = n.Depth + 1
 		newNode.Left  = i
 		newNode.End   = len(xt.Keys[i]) == (n.Depth + 1)
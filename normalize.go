@@ -0,0 +1,131 @@
+// 文本规范化
+// 设置Normalizer之后，编译和插入时规范化词，检索时规范化输入的内容，"ABC"和"abc"、全角"ＡＢＣ"、繁体和简体可以互相命中
+// Normalizer逐个字符转换，转换前后字符数量不变，检索结果的位置对应原始内容
+// 内置ASCII大小写、全角转半角、繁体转简体，可以通过Normalizers组合
+
+package xtrie
+
+import "unicode/utf8"
+
+// 字符规范化函数，同一个字符多次转换的结果必须相同
+type Normalizer func(rune) rune
+
+// 组合多个规范化函数，按照顺序转换
+func Normalizers(normalizers ...Normalizer) Normalizer {
+	return func(r rune) rune {
+		for _, n := range normalizers {
+			r = n(r)
+		}
+		return r
+	}
+}
+
+// ASCII大写字母转换为小写
+func FoldASCII(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
+
+// 全角字符转换为半角，包括全角空格
+func FoldWidth(r rune) rune {
+	if r == '　' {
+		return ' '
+	}
+	if r >= '！' && r <= '～' {
+		return r - 0xFEE0
+	}
+	return r
+}
+
+// 常用繁体字转换为简体字，一对多的字按照最常用的简体字转换
+func FoldTraditional(r rune) rune {
+	if s, ok := traditional[r]; ok {
+		return s
+	}
+	return r
+}
+
+var traditional = func() map[rune]rune {
+	simplified := []rune(simplifiedRunes)
+	m := make(map[rune]rune, len(simplified))
+	for i, r := range []rune(traditionalRunes) {
+		m[r] = simplified[i]
+	}
+	return m
+}()
+
+// 规范化词
+func (x *XTrie) _normalizeKey(key string) string {
+	if x.Normalizer == nil {
+		return key
+	}
+	text, _ := x._normalize(key)
+	return text
+}
+
+// 规范化内容
+// 返回 规范化之后的内容，每个字节位置对应的原始内容的字节位置，长度为len(text)+1，内容没有变化时为nil
+func (x *XTrie) _normalize(key string) (string, []int) {
+	if x.Normalizer == nil {
+		return key, nil
+	}
+	buf := make([]byte, 0, len(key))
+	offsets := make([]int, 0, len(key)+1)
+	changed := false
+	for i := 0; i < len(key); {
+		r, size := utf8.DecodeRuneInString(key[i:])
+		n := len(buf)
+		if r == utf8.RuneError && size == 1 { //无效的字节保持不变
+			buf = append(buf, key[i])
+		} else {
+			buf = utf8.AppendRune(buf, x.Normalizer(r))
+		}
+		changed = changed || len(buf)-n != size || string(buf[n:]) != key[i:i+size]
+		for ; n < len(buf); n++ {
+			offsets = append(offsets, i)
+		}
+		i += size
+	}
+	if !changed {
+		return key, nil
+	}
+	return string(buf), append(offsets, len(key))
+}
+
+// 繁体字，和simplifiedRunes逐字对应
+const traditionalRunes = "萬與專業東絲兩嚴個豐臨為麗舉義烏樂喬習鄉書買亂爭虧雲亞產畝親億僅從倉儀們價眾優會" +
+	"傘偉傳傷倫偽體餘來侖俠倆債傾儉兒黨蘭關興養獸內岡冊寫軍農馮沖決況凍淨減湊幾鳳憑凱" +
+	"擊鑿劃劉則剛創刪別剎劑劍劇勸辦務動勵勁勞勢勳區醫華協單賣盧衛卻廠廳歷厲壓厭廁縣參" +
+	"雙發變敘疊葉號嘆嗎啟吳員問啞喚喪團園圍圖國圓聖場壞塊堅壇墊報塵壯聲殼處備復夠頭夾" +
+	"奪奮獎婦媽孫學寧寶實寵審憲宮對尋導將層屬歲島嶺峽幣帥師帳帶幫廣莊慶廬庫應廟開異棄" +
+	"張彈強歸當錄徹徑後憶懷態總戀惡悅懸驚慘慣憤願懶戲戰戶撲執擴掃揚擾撫搶護擔擬攏揀擁" +
+	"攔擰撥擇掛擋擠揮損換撿據攜搖擺數斂斷無舊時曠暢顯晉曬曉暈暫術機殺雜權條楊極構槍樞" +
+	"標棟欄樹樣橋檢樓槳橫歡歐殘殲毀氣漢湯溝沒溫灣濟淚濃滅燈災爐點煉爛熱愛爺牆犧狀猶獨" +
+	"獄獲貓現環瑪電畫療瘋癢皺盜盞監盤睜矯碼礎確礦禮禍離種積稱穩窮竊競筆築簡籃糧緊紅約" +
+	"級紀純紙納線練組細終經結給絕統網綠維編緣繼續罰羅聯聰職聽腦腳膠臉臟藝節蘇藥蘋虛蟲" +
+	"衝補裝製複見規視覺覽觀計訂認討讓訓議記講許論設訪證評識詞試詩話誠該語說請讀課誰調" +
+	"談謝貝負財貨質購貴費貿資賓賞賽贊贏趕趙躍蹤車軌軟轉輪輕載較輔輸辭邊遼達遷過運還這" +
+	"進遠違連遲適選遺鄧鄭醜釋針鐘鋼錢鐵鑰銀鍋錯鍵長門閃閉間閱闊隊陽陰陣階際陸陳險隨隱" +
+	"難雞雖霧靜韓頁頂項順須預領頻題額顏風飛飯飲館馬駕驗騎鬥魚鮮鳥鴨麥黃齊齒龍龜灑夢貞" +
+	"敵漁罵髮鬆蕭濕顧響驅聞壽瞭傑韻顆營纖綜縮績繩織繪絡紛鬧閣錶鏡鐳銷鑄鄰郵遞邏輯輻賴" +
+	"賺賬賦賜賀貸貫責貢謎謀譯謹誤誘詳詢診訴訊託"
+
+// 简体字
+const simplifiedRunes = "万与专业东丝两严个丰临为丽举义乌乐乔习乡书买乱争亏云亚产亩亲亿仅从仓仪们价众优会" +
+	"伞伟传伤伦伪体余来仑侠俩债倾俭儿党兰关兴养兽内冈册写军农冯冲决况冻净减凑几凤凭凯" +
+	"击凿划刘则刚创删别刹剂剑剧劝办务动励劲劳势勋区医华协单卖卢卫却厂厅历厉压厌厕县参" +
+	"双发变叙叠叶号叹吗启吴员问哑唤丧团园围图国圆圣场坏块坚坛垫报尘壮声壳处备复够头夹" +
+	"夺奋奖妇妈孙学宁宝实宠审宪宫对寻导将层属岁岛岭峡币帅师帐带帮广庄庆庐库应庙开异弃" +
+	"张弹强归当录彻径后忆怀态总恋恶悦悬惊惨惯愤愿懒戏战户扑执扩扫扬扰抚抢护担拟拢拣拥" +
+	"拦拧拨择挂挡挤挥损换捡据携摇摆数敛断无旧时旷畅显晋晒晓晕暂术机杀杂权条杨极构枪枢" +
+	"标栋栏树样桥检楼桨横欢欧残歼毁气汉汤沟没温湾济泪浓灭灯灾炉点炼烂热爱爷墙牺状犹独" +
+	"狱获猫现环玛电画疗疯痒皱盗盏监盘睁矫码础确矿礼祸离种积称稳穷窃竞笔筑简篮粮紧红约" +
+	"级纪纯纸纳线练组细终经结给绝统网绿维编缘继续罚罗联聪职听脑脚胶脸脏艺节苏药苹虚虫" +
+	"冲补装制复见规视觉览观计订认讨让训议记讲许论设访证评识词试诗话诚该语说请读课谁调" +
+	"谈谢贝负财货质购贵费贸资宾赏赛赞赢赶赵跃踪车轨软转轮轻载较辅输辞边辽达迁过运还这" +
+	"进远违连迟适选遗邓郑丑释针钟钢钱铁钥银锅错键长门闪闭间阅阔队阳阴阵阶际陆陈险随隐" +
+	"难鸡虽雾静韩页顶项顺须预领频题额颜风飞饭饮馆马驾验骑斗鱼鲜鸟鸭麦黄齐齿龙龟洒梦贞" +
+	"敌渔骂发松萧湿顾响驱闻寿了杰韵颗营纤综缩绩绳织绘络纷闹阁表镜镭销铸邻邮递逻辑辐赖" +
+	"赚账赋赐贺贷贯责贡谜谋译谨误诱详询诊诉讯托"
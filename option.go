@@ -0,0 +1,224 @@
+// 检索选项
+// 内容检索通过可选参数设置匹配模式等，不传选项时和原来的行为一致
+
+package xtrie
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// 内容检索的匹配模式
+type MatchMode int
+
+const (
+	MatchAll             MatchMode = iota // 返回所有的词，包括相互重叠的词
+	MatchLeftmostLongest                  // 从左到右，同一位置开始的词只取最长的，命中之后从词的结尾继续查找，结果不重叠
+	MatchLeftmostFirst                    // 从左到右，同一位置开始的词只取最先加入词库的(词id最小)，结果不重叠
+	MatchShortest                         // 从左到右，同一位置开始的词只取最短的，结果不重叠
+)
+
+// 内容检索选项
+type SearchOption func(*searchOptions)
+
+type searchOptions struct {
+	mode  MatchMode
+	skip  *SkipSet
+	chunk int // 流式检索每次读取的字节数，0使用默认值
+
+	ranged   bool             // 是否设置了等级范围
+	min, max int              // 等级范围，包括min和max
+	levels   map[int]struct{} // 等级集合，为空时不限制
+
+	order  PrefixOrder               // 前缀检索的排序方式
+	weight func(MatchResult) float64 // 前缀检索按照权重排序时每个词的权重
+	offset int                       // 前缀检索分页的偏移量
+}
+
+// 前缀检索的排序方式
+type PrefixOrder int
+
+const (
+	OrderLexical PrefixOrder = iota // 按照字典序，默认
+	OrderLevel                      // 按照等级从高到低，等级相同时按照字典序
+	OrderWeight                     // 按照WithWeight设置的权重从高到低，权重相同时按照字典序
+)
+
+// 设置前缀检索的排序方式
+func WithOrder(order PrefixOrder) SearchOption {
+	return func(o *searchOptions) {
+		o.order = order
+	}
+}
+
+// 前缀检索按照权重从高到低排序，每个词调用一次weight
+func WithWeight(weight func(MatchResult) float64) SearchOption {
+	return func(o *searchOptions) {
+		o.order = OrderWeight
+		o.weight = weight
+	}
+}
+
+// 前缀检索跳过前offset个词，和limit一起分页
+func WithOffset(offset int) SearchOption {
+	return func(o *searchOptions) {
+		o.offset = max(offset, 0)
+	}
+}
+
+// 内容检索时词中间可以跳过的干扰字符，如"敏*感"、"f.u.c.k"、零宽字符
+// 词的第一个字符不会跳过，命中的结果Word为词库中的词，Start和End覆盖内容中包括干扰字符的原文
+type SkipSet struct {
+	Categories []*unicode.RangeTable // 跳过属于这些Unicode分类的字符，如unicode.P、unicode.Z、unicode.Cf
+	Runes      []rune                // 跳过这些字符
+	MaxGap     int                   // 两个字符之间最多连续跳过的字符数，0为不限制；没有设置分类和字符时，跳过任意不能继续匹配的字符
+}
+
+// 设置匹配模式，默认MatchAll
+func WithMode(mode MatchMode) SearchOption {
+	return func(o *searchOptions) {
+		o.mode = mode
+	}
+}
+
+// 只返回等级在min和max之间的词，包括min和max
+// 检索过程中直接跳过等级不满足的词，不会为这些词分配内存
+func WithLevels(min, max int) SearchOption {
+	return func(o *searchOptions) {
+		o.ranged = true
+		o.min, o.max = min, max
+	}
+}
+
+// 只返回等级在levels中的词，和WithLevels同时使用时两个条件都需要满足
+func WithLevelSet(levels ...int) SearchOption {
+	return func(o *searchOptions) {
+		o.levels = make(map[int]struct{}, len(levels))
+		for _, level := range levels {
+			o.levels[level] = struct{}{}
+		}
+	}
+}
+
+// 等级是否满足条件
+func (o *searchOptions) _accept(level int) bool {
+	if o.ranged && (level < o.min || level > o.max) {
+		return false
+	}
+	if o.levels != nil {
+		_, ok := o.levels[level]
+		return ok
+	}
+	return true
+}
+
+// 设置内容检索时跳过的干扰字符
+// 当前字符不能继续匹配时才会跳过，开启ACMode时也使用逐字查找
+func WithSkip(skip SkipSet) SearchOption {
+	return func(o *searchOptions) {
+		o.skip = &skip
+	}
+}
+
+// 字符是否可以跳过
+// 参数 gap int 已经连续跳过的字符数
+func (s *SkipSet) _skip(r rune, gap int) bool {
+	if s.MaxGap > 0 && gap >= s.MaxGap {
+		return false
+	}
+	if len(s.Categories) == 0 && len(s.Runes) == 0 {
+		return s.MaxGap > 0
+	}
+	for _, c := range s.Runes {
+		if c == r {
+			return true
+		}
+	}
+	return unicode.IsOneOf(s.Categories, r)
+}
+
+// 比较后缀，后缀中间的干扰字符同样跳过
+// 返回 结束位置，是否匹配，是否跳过了字符
+func (x *XTrie) _skipTail(s string, i int, tail []rune, skip *SkipSet) (int, bool, bool) {
+	gap, skipped := 0, false
+	for k := 0; k < len(tail); {
+		if i >= len(s) {
+			return i, false, skipped
+		}
+		if r, size := x._unit(s, i); r == tail[k] {
+			i += size
+			k++
+			gap = 0
+			continue
+		}
+		if r, size := utf8.DecodeRuneInString(s[i:]); skip._skip(r, gap) {
+			i += size
+			gap++
+			skipped = true
+			continue
+		}
+		return i, false, skipped
+	}
+	return i, true, skipped
+}
+
+// 合并所有的选项
+func _searchOptions(opts []SearchOption) searchOptions {
+	var o searchOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// 同一位置开始的两个词，a是否比b更优先
+// 参数 ida, idb int 词id，MatchLeftmostFirst模式使用
+func (o *searchOptions) _prefer(a, b MatchResult, ida, idb int) bool {
+	switch o.mode {
+	case MatchLeftmostLongest:
+		return a.End > b.End
+	case MatchLeftmostFirst:
+		return ida < idb
+	case MatchShortest:
+		return a.End < b.End
+	}
+	return false
+}
+
+// 按照匹配模式从开始位置有序的结果中逐个选出不重叠的词
+// 同一位置开始的词选出一个，和已经选出的词重叠的词跳过
+type selector struct {
+	x    *XTrie
+	o    *searchOptions
+	fn   func(MatchResult) bool
+	best MatchResult // 当前开始位置选出的词
+	has  bool        // 当前开始位置是否有词
+	end  int         // 已经选出的词的结束位置
+}
+
+// 加入下一个词，开始位置变化时回调之前选出的词
+func (s *selector) _push(r MatchResult) bool {
+	if s.has && r.Start == s.best.Start {
+		if s.o._prefer(r, s.best, s.x.Keymap[r.Word], s.x.Keymap[s.best.Word]) {
+			s.best = r
+		}
+		return true
+	}
+	if !s._flush() {
+		return false
+	}
+	if r.Start >= s.end {
+		s.best, s.has = r, true
+	}
+	return true
+}
+
+// 回调当前开始位置选出的词
+func (s *selector) _flush() bool {
+	if !s.has {
+		return true
+	}
+	s.has = false
+	s.end = s.best.End
+	return s.fn(s.best)
+}
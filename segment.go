@@ -0,0 +1,142 @@
+// 基于词库的最大匹配分词
+// 正向最大匹配：从左到右每次取最长的词
+// 逆向最大匹配：从右到左每次取最长的词
+// 双向最大匹配：分别正向和逆向分词，词数少的优先，词数相同时单字少的优先，都相同时使用逆向的结果
+// 分词结果按照顺序覆盖整个文本，词库中没有的连续字符合并为一个未知词
+
+package xtrie
+
+import "unicode/utf8"
+
+// 分词方式
+type SegmentMode int
+
+const (
+	SegmentForward       SegmentMode = iota // 正向最大匹配
+	SegmentBackward                         // 逆向最大匹配
+	SegmentBidirectional                    // 双向最大匹配
+)
+
+// 分词结果
+type Token struct {
+	MatchResult
+	Known bool `json:"known"` // 是否是词库中的词，false为词库中没有的连续字符，等级为0
+}
+
+// 分词，返回按照顺序覆盖整个文本的词
+func (x *XTrie) Segment(text string, mode SegmentMode) []Token {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x._segment(text, mode)
+}
+
+func (x *XTrie) _segment(text string, mode SegmentMode) []Token {
+	var o searchOptions
+	matches := x._search(text, &o)
+	var tokens []Token
+	switch mode {
+	case SegmentForward:
+		tokens = x._forward(text, matches)
+	case SegmentBackward:
+		tokens = x._backward(text, matches)
+	default:
+		tokens = x._bidirectional(text, matches)
+	}
+	//未知词没有字符位置，按照顺序重新计算
+	runes := 0
+	for i := range tokens {
+		tokens[i].RuneStart = runes
+		runes += utf8.RuneCountInString(tokens[i].Word)
+		tokens[i].RuneEnd = runes
+	}
+	return tokens
+}
+
+// 双向最大匹配
+func (x *XTrie) _bidirectional(text string, matches []MatchResult) []Token {
+	forward, backward := x._forward(text, matches), x._backward(text, matches)
+	if len(forward) != len(backward) {
+		if len(forward) < len(backward) {
+			return forward
+		}
+		return backward
+	}
+	if x._singles(forward) < x._singles(backward) {
+		return forward
+	}
+	return backward
+}
+
+// 正向最大匹配
+// 参数 matches MatchResult切片 文本中所有的词，按照开始位置排序
+func (x *XTrie) _forward(text string, matches []MatchResult) []Token {
+	longest := make([]int32, len(text)) //每个位置开始的最长的词在matches中的索引+1
+	for i, m := range matches {
+		longest[m.Start] = int32(i + 1)
+	}
+	var tokens []Token
+	for i := 0; i < len(text); {
+		if m := longest[i]; m > 0 {
+			tokens = append(tokens, Token{matches[m-1], true})
+			i = matches[m-1].End
+			continue
+		}
+		_, size := x._unit(text, i)
+		tokens = _unknown(tokens, text, i, i+size)
+		i += size
+	}
+	return tokens
+}
+
+// 逆向最大匹配
+// 参数 matches MatchResult切片 文本中所有的词，按照开始位置排序
+func (x *XTrie) _backward(text string, matches []MatchResult) []Token {
+	longest := make([]int32, len(text)+1) //每个位置结束的最长的词在matches中的索引+1
+	for i := len(matches) - 1; i >= 0; i-- {
+		longest[matches[i].End] = int32(i + 1)
+	}
+	var tokens []Token
+	for j := len(text); j > 0; {
+		if m := longest[j]; m > 0 {
+			tokens = append(tokens, Token{matches[m-1], true})
+			j = matches[m-1].Start
+			continue
+		}
+		size := 1
+		if !x.ByteMode {
+			_, size = utf8.DecodeLastRuneInString(text[:j])
+		}
+		if n := len(tokens); n > 0 && !tokens[n-1].Known { //和后面的未知字符合并
+			tokens[n-1].Start = j - size
+			tokens[n-1].Word = text[j-size : tokens[n-1].End]
+		} else {
+			tokens = append(tokens, Token{MatchResult{Word: text[j-size : j], Start: j - size, End: j}, false})
+		}
+		j -= size
+	}
+	for i, k := 0, len(tokens)-1; i < k; i, k = i+1, k-1 {
+		tokens[i], tokens[k] = tokens[k], tokens[i]
+	}
+	return tokens
+}
+
+// 追加未知字符，和前面的未知字符合并
+func _unknown(tokens []Token, text string, start, end int) []Token {
+	if n := len(tokens); n > 0 && !tokens[n-1].Known {
+		tokens[n-1].End = end
+		tokens[n-1].Word = text[tokens[n-1].Start:end]
+		return tokens
+	}
+	return append(tokens, Token{MatchResult{Word: text[start:end], Start: start, End: end}, false})
+}
+
+// 单个字符的词数量
+func (x *XTrie) _singles(tokens []Token) int {
+	count := 0
+	for _, t := range tokens {
+		if _, size := x._unit(t.Word, 0); size == len(t.Word) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,247 @@
+// 只读快照
+// Snapshot和XTrie共享同一份结构数据，不复制
+// 快照存在时，XTrie第一次原地修改结构之前先复制一份数据(写时复制)，快照看到的数据永远不会变化
+// 重新构建总是在新的结构上完成，替换之后旧的快照继续使用旧的一代结构
+
+package xtrie
+
+import (
+	"io"
+	"iter"
+	"slices"
+)
+
+// 只读快照结构体
+type Snapshot struct {
+	x          *XTrie // 共享数据的只读结构，不会再被修改
+	Generation uint64 // 快照对应的结构代数，每次修改或者替换结构加1
+}
+
+// 获取当前结构的只读快照
+// 快照的检索不需要加锁，也不会被之后的插入、删除、重新构建影响
+func (x *XTrie) Snapshot() *Snapshot {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.shared = true
+	return &Snapshot{x: x._view(), Generation: x.gen}
+}
+
+// 当前结构的浅复制，和x共享所有的切片以及map
+func (x *XTrie) _view() *XTrie {
+	v := &XTrie{
+		Fmd5:     x.Fmd5,
+		Size:     x.Size,
+		Array:    x.Array,
+		Keys:     x.Keys,
+		Keymap:   x.Keymap,
+		Levels:   x.Levels,
+		MaxCode:  x.MaxCode,
+		maxLen:   x.maxLen,
+		ByteMode: x.ByteMode,
+		ACMode:   x.ACMode,
+		gen:      x.gen,
+		links:    x.links,
+		Tail:     x.Tail,
+		Tails:    x.Tails,
+		Codes:    x.Codes,
+		Runes:    x.Runes,
+
+		Normalizer: x.Normalizer,
+	}
+	v._setTree(x._tree())
+	return v
+}
+
+// 原地修改结构之前调用，加写锁，如果数据被快照共享，先复制一份
+// 调用方需要持有wmu，复制在加写锁之前完成，不阻塞检索
+func (x *XTrie) _lock() {
+	x.mu.RLock()
+	shared := x.shared
+	x.mu.RUnlock()
+	var tmp *XTrie
+	if shared {
+		tmp = x._copy()
+	}
+	x.mu.Lock()
+	if x.shared {
+		if tmp == nil { //检查之后又创建了快照
+			tmp = x._copy()
+		}
+		x.Array = tmp.Array
+		x.Keymap, x.Levels = tmp.Keymap, tmp.Levels
+		x.Tail, x.Tails = tmp.Tail, tmp.Tails
+		x.Codes, x.Runes = tmp.Codes, tmp.Runes
+		x._setTree(tmp._tree())
+		x.links = tmp.links
+		x.shared = false
+	}
+	if x.links != nil && (x.links.gen != x.gen || !x._acLive()) { //失效的链接不能增量更新，修改之后重新计算
+		x.links = nil
+	}
+}
+
+// 深复制结构数据
+func (x *XTrie) _copy() *XTrie {
+	tmp := x._fork()
+	tmp.Array = make([]int32, len(x.Array))
+	copy(tmp.Array, x.Array)
+	if tree := x._tree(); tree != nil {
+		tmp._setTree(slices.Clone(tree))
+	}
+	if x.links != nil {
+		tmp.links = x.links._copy()
+	}
+	tmp.Tail = make([]rune, len(x.Tail))
+	copy(tmp.Tail, x.Tail)
+	tmp.Tails = make([]int, len(x.Tails))
+	copy(tmp.Tails, x.Tails)
+	if x.Codes != nil {
+		tmp.Codes = make(map[rune]int, len(x.Codes))
+		for r, code := range x.Codes {
+			tmp.Codes[r] = code
+		}
+		tmp.Runes = make([]rune, len(x.Runes))
+		copy(tmp.Runes, x.Runes)
+	}
+	return tmp
+}
+
+// 精确查找，同XTrie.Match
+func (s *Snapshot) Match(key string, forceBack bool) (int, int, error) {
+	return s.x._match(key, forceBack)
+}
+
+// 内容检索，同XTrie.Search
+func (s *Snapshot) Search(key string, opts ...SearchOption) []MatchResult {
+	o := _searchOptions(opts)
+	return s.x._search(key, &o)
+}
+
+// 分词，同XTrie.Segment
+func (s *Snapshot) Segment(text string, mode SegmentMode) []Token {
+	return s.x._segment(text, mode)
+}
+
+// 掩码，同XTrie.Mask
+func (s *Snapshot) Mask(text string, mask rune, opts ...MaskOption) string {
+	return s.x._mask(text, mask, opts)
+}
+
+// 替换，同XTrie.Replace
+func (s *Snapshot) Replace(text string, fn func(MatchResult) string) string {
+	return s.x._replace(text, fn)
+}
+
+// 流式检索，同XTrie.SearchReader
+func (s *Snapshot) SearchReader(r io.Reader, fn func(MatchResult) bool, opts ...SearchOption) error {
+	o := _searchOptions(opts)
+	return _searchReader(r, fn, &o, s.x._window(&o), func(text string) []MatchResult {
+		return s.x._search(text, &o)
+	})
+}
+
+// 回调内容检索，同XTrie.SearchFunc
+func (s *Snapshot) SearchFunc(text string, fn func(MatchResult) bool, opts ...SearchOption) {
+	o := _searchOptions(opts)
+	s.x._searchFunc(text, &o, fn)
+}
+
+// 回调前缀检索，同XTrie.PrefixFunc
+func (s *Snapshot) PrefixFunc(pre string, fn func(MatchResult) bool, opts ...SearchOption) {
+	o := _searchOptions(opts)
+	s.x._prefixEach(pre, &o, _keepWord(fn))
+}
+
+// 回调后缀检索，同XTrie.SuffixFunc
+func (s *Snapshot) SuffixFunc(key string, fn func(MatchResult) bool, opts ...SearchOption) {
+	o := _searchOptions(opts)
+	s.x._suffixEach(key, &o, _keepWord(fn))
+}
+
+// 回调模糊检索，同XTrie.FuzzyFunc
+func (s *Snapshot) FuzzyFunc(text string, fn func(MatchResult) bool, opts ...SearchOption) {
+	o := _searchOptions(opts)
+	s.x._fuzzyEach(text, &o, _keepWord(fn))
+}
+
+// 迭代器内容检索，同XTrie.SearchSeq
+func (s *Snapshot) SearchSeq(text string, opts ...SearchOption) iter.Seq[MatchResult] {
+	return func(yield func(MatchResult) bool) {
+		s.SearchFunc(text, yield, opts...)
+	}
+}
+
+// 迭代器前缀检索，同XTrie.PrefixSeq
+func (s *Snapshot) PrefixSeq(pre string, opts ...SearchOption) iter.Seq[MatchResult] {
+	return func(yield func(MatchResult) bool) {
+		s.PrefixFunc(pre, yield, opts...)
+	}
+}
+
+// 迭代器后缀检索，同XTrie.SuffixSeq
+func (s *Snapshot) SuffixSeq(key string, opts ...SearchOption) iter.Seq[MatchResult] {
+	return func(yield func(MatchResult) bool) {
+		s.SuffixFunc(key, yield, opts...)
+	}
+}
+
+// 迭代器模糊检索，同XTrie.FuzzySeq
+func (s *Snapshot) FuzzySeq(text string, opts ...SearchOption) iter.Seq[MatchResult] {
+	return func(yield func(MatchResult) bool) {
+		s.FuzzyFunc(text, yield, opts...)
+	}
+}
+
+// 公共前缀查找，同XTrie.CommonPrefixes
+func (s *Snapshot) CommonPrefixes(input string, opts ...SearchOption) []MatchResult {
+	o := _searchOptions(opts)
+	o.mode = MatchAll
+	var result []MatchResult
+	s.x._commonPrefixes(input, &o, func(r MatchResult) bool {
+		result = append(result, r)
+		return true
+	})
+	return result
+}
+
+// 最长前缀查找，同XTrie.LongestPrefix
+func (s *Snapshot) LongestPrefix(input string, opts ...SearchOption) (MatchResult, bool) {
+	o := _searchOptions(opts)
+	o.mode = MatchLeftmostLongest
+	var result MatchResult
+	found := false
+	s.x._commonPrefixes(input, &o, func(r MatchResult) bool {
+		result, found = r, true
+		return true
+	})
+	return result, found
+}
+
+// 前缀检索，同XTrie.Prefix
+func (s *Snapshot) Prefix(pre string, limit int, opts ...SearchOption) ([]MatchResult, error) {
+	o := _searchOptions(opts)
+	return s.x._prefixWords(pre, limit, &o)
+}
+
+// 分页前缀检索，同XTrie.PrefixPage
+func (s *Snapshot) PrefixPage(pre string, limit int, opts ...SearchOption) ([]MatchResult, int, error) {
+	o := _searchOptions(opts)
+	return s.x._prefixPage(pre, limit, &o)
+}
+
+// 后缀检索，同XTrie.Suffix
+func (s *Snapshot) Suffix(key string, limit int, opts ...SearchOption) ([]MatchResult, error) {
+	o := _searchOptions(opts)
+	return s.x._suffix(key, limit, &o)
+}
+
+// 模糊检索，同XTrie.Fuzzy
+func (s *Snapshot) Fuzzy(key string, limit int, opts ...SearchOption) ([]MatchResult, error) {
+	o := _searchOptions(opts)
+	return s.x._fuzzy(key, limit, &o)
+}
+
+// 保存快照，保存过程中不阻塞XTrie的插入和删除
+func (s *Snapshot) Store(path string) error {
+	return s.x.Store(path)
+}
@@ -0,0 +1,125 @@
+// 流式内容检索
+// 从io.Reader分块读取内容，不需要把全部内容放到内存中，适合大文件和持续输入的数据
+// 每次检索保留最长的词需要的字符，跨越两个分块的词同样可以命中，结果的位置是在整个输入中的位置
+
+package xtrie
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// 每次读取的字节数
+const readChunk = 64 << 10
+
+// 跳过字符没有设置MaxGap时，计算窗口使用的两个字符之间的最大间隔
+const streamGap = 64
+
+// 流式检索，按照内容中的位置顺序回调命中的词
+// 参数 fn func(MatchResult) bool 返回false时停止检索，回调时不持有读锁
+// 检索过程中插入的比已有的词更长的词，在分块边界上可能无法命中
+func (x *XTrie) SearchReader(r io.Reader, fn func(MatchResult) bool, opts ...SearchOption) error {
+	o := _searchOptions(opts)
+	x.mu.RLock()
+	window := x._window(&o)
+	x.mu.RUnlock()
+	return _searchReader(r, fn, &o, window, func(text string) []MatchResult {
+		x.mu.RLock()
+		defer x.mu.RUnlock()
+		return x._search(text, &o)
+	})
+}
+
+// 词可能覆盖的最大字符数，包括跳过的干扰字符
+func (x *XTrie) _window(o *searchOptions) int {
+	longest := max(x.maxLen, 1)
+	if o.skip != nil {
+		gap := o.skip.MaxGap
+		if gap <= 0 {
+			gap = streamGap
+		}
+		longest += (longest - 1) * gap
+	}
+	return longest
+}
+
+// 分块读取内容并检索
+// 参数 window int 词可能覆盖的最大字符数，search func(string) []MatchResult 检索一段内容，结果按照开始位置排序
+func _searchReader(r io.Reader, fn func(MatchResult) bool, o *searchOptions, window int, search func(string) []MatchResult) error {
+	chunk := o.chunk
+	if chunk <= 0 {
+		chunk = readChunk
+	}
+	buf := make([]byte, 0, chunk*2)
+	base, baseRunes := 0, 0 //buf第一个字节在整个输入中的字节位置和字符位置
+	eof := false
+	for {
+		if cap(buf)-len(buf) < chunk {
+			nb := make([]byte, len(buf), len(buf)+chunk*2)
+			copy(nb, buf)
+			buf = nb
+		}
+		n, err := r.Read(buf[len(buf) : len(buf)+chunk])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			eof = true
+		} else if err != nil {
+			return err
+		}
+		//safe之前开始的词在buf中已经有足够的字符，可以确定结果
+		end, safe := len(buf), len(buf)
+		if !eof {
+			end = _fullRunes(buf)
+			safe = _backRunes(buf[:end], window-1)
+			if safe == 0 {
+				continue
+			}
+		}
+		cut := safe
+		for _, m := range search(string(buf[:end])) {
+			if m.Start >= safe {
+				break
+			}
+			if o.mode != MatchAll && m.End > cut { //不重叠的模式从命中词的结尾继续查找
+				cut = m.End
+			}
+			m.Start, m.End = m.Start+base, m.End+base
+			m.RuneStart, m.RuneEnd = m.RuneStart+baseRunes, m.RuneEnd+baseRunes
+			if !fn(m) {
+				return nil
+			}
+		}
+		if eof {
+			return nil
+		}
+		base += cut
+		baseRunes += utf8.RuneCount(buf[:cut])
+		buf = buf[:copy(buf, buf[cut:])]
+	}
+}
+
+// 去掉结尾不完整的UTF-8字符，返回完整字符的结束位置
+func _fullRunes(buf []byte) int {
+	for k := 1; k <= utf8.UTFMax && k <= len(buf); k++ {
+		if utf8.RuneStart(buf[len(buf)-k]) {
+			if !utf8.FullRune(buf[len(buf)-k:]) {
+				return len(buf) - k
+			}
+			break
+		}
+	}
+	return len(buf)
+}
+
+// 从结尾向前n个字符的位置，字符数不足n时返回0
+func _backRunes(buf []byte, n int) int {
+	end := len(buf)
+	for ; n > 0 && end > 0; n-- {
+		_, size := utf8.DecodeLastRune(buf[:end])
+		end -= size
+	}
+	if n > 0 {
+		return 0
+	}
+	return end
+}
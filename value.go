@@ -0,0 +1,255 @@
+// 带值的XTrie
+// 每个词结尾节点存储的词id同时作为Values切片的索引
+// 可以给词附加任意类型的数据，比如分类、替换词、来源id等
+// 检索结果在MatchResult的基础上返回词对应的值
+
+package xtrie
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+)
+
+// 带值的查询返回值结构体
+type ValueResult[V any] struct {
+	MatchResult
+	Value V `json:"value"`
+}
+
+// 泛型XTrie，Values按照词id存储每个词的值
+type ValueTrie[V any] struct {
+	*XTrie
+	Values []V // 词id对应的值
+}
+
+// 创建空的ValueTrie
+// 只在内存中维护结构，需要持久化时调用Store
+func NewValueTrie[V any]() *ValueTrie[V] {
+	vt := &ValueTrie[V]{XTrie: new(XTrie)}
+	vt.reset()
+	return vt
+}
+
+// 设置词id对应的值
+func (vt *ValueTrie[V]) _setValue(id int, value V) {
+	if id >= len(vt.Values) {
+		values := make([]V, id+1, (id+1)*5/4+1)
+		copy(values, vt.Values)
+		vt.Values = values
+	}
+	vt.Values[id] = value
+}
+
+// 获取词id对应的值，没有设置过值的词返回零值
+func (vt *ValueTrie[V]) _value(id int) V {
+	var value V
+	if id >= 0 && id < len(vt.Values) {
+		value = vt.Values[id]
+	}
+	return value
+}
+
+// 根据词查找到词id，返回带值的结果集
+func (vt *ValueTrie[V]) _results(result []MatchResult) []ValueResult[V] {
+	values := make([]ValueResult[V], 0, len(result))
+	for _, r := range result {
+		id, ok := vt.Keymap[r.Word]
+		if !ok {
+			id = -1
+		}
+		values = append(values, ValueResult[V]{r, vt._value(id)})
+	}
+	return values
+}
+
+// 批量添加词，不编译结构
+// 添加完所有的词之后调用Build
+func (vt *ValueTrie[V]) Add(key string, level int, value V) {
+	vt.wmu.Lock()
+	defer vt.wmu.Unlock()
+	vt._lock()
+	defer vt.mu.Unlock()
+	vt.gen++ //已经存在的词直接更新等级
+	vt._setValue(vt._setLevel(vt._normalizeKey(key), level), value)
+}
+
+// 编译新的结构并替换当前结构，按词把值对应到新结构的词id
+// 读取词典时词id按照词典中的顺序重新分配，新的词值为零值
+func (vt *ValueTrie[V]) _rebuild(tmp *XTrie) error {
+	err := tmp.build()
+	if err != nil {
+		return err
+	}
+	values := make([]V, len(tmp.Levels))
+	for word, id := range tmp.Keymap {
+		if old, ok := vt.Keymap[word]; ok {
+			values[id] = vt._value(old)
+		}
+	}
+	vt.mu.Lock()
+	vt._swap(tmp)
+	vt.Values = values
+	vt.mu.Unlock()
+	return nil
+}
+
+// 读取词典，同XTrie.DictRead，已有的词保留原来的值
+func (vt *ValueTrie[V]) DictRead() (bool, error) {
+	vt.wmu.Lock()
+	defer vt.wmu.Unlock()
+	tmp, err := vt._dictRead()
+	if tmp == nil {
+		return err == nil, err
+	}
+	return false, vt._rebuild(tmp)
+}
+
+// 加载store文件，读取词典，编译并保存，同XTrie.InitHandle，值随store一起加载和保存
+func (vt *ValueTrie[V]) InitHandle(storeFile string, dictFile string) {
+	if vt.XTrie == nil {
+		vt.XTrie = new(XTrie)
+	}
+	vt._initHandle(vt, storeFile, dictFile)
+}
+
+// 编译所有已添加的词
+func (vt *ValueTrie[V]) Build() error {
+	vt.wmu.Lock()
+	defer vt.wmu.Unlock()
+	return vt._rebuild(vt._fork())
+}
+
+// 动态添加带值的词
+func (vt *ValueTrie[V]) Insert(key string, level int, value V) error {
+	vt.wmu.Lock()
+	defer vt.wmu.Unlock()
+	err := vt._insert(key, level)
+	if err != nil {
+		return err
+	}
+	vt.mu.Lock()
+	vt._setValue(vt.Keymap[vt._normalizeKey(key)], value) //Keymap中的词是规范化之后的
+	vt.mu.Unlock()
+	return nil
+}
+
+// 删除词以及词对应的值
+func (vt *ValueTrie[V]) Remove(key string) error {
+	vt.wmu.Lock()
+	defer vt.wmu.Unlock()
+	key = vt._normalizeKey(key)
+	id, ok := vt.Keymap[key]
+	if !ok {
+		return errors.New("not found")
+	}
+	err := vt._remove(key)
+	if err != nil {
+		return err
+	}
+	var zero V
+	vt.mu.Lock()
+	vt._setValue(id, zero)
+	vt.mu.Unlock()
+	return nil
+}
+
+// 精确查找词，返回词对应的值和等级
+func (vt *ValueTrie[V]) Match(key string) (V, int, error) {
+	vt.mu.RLock()
+	defer vt.mu.RUnlock()
+	index, level, err := vt._match(key, false)
+	if err != nil {
+		var zero V
+		return zero, level, err
+	}
+	return vt._value(vt._wordID(index)), level, nil
+}
+
+// 内容检索，返回内容中出现的词以及词对应的值
+func (vt *ValueTrie[V]) Search(key string, opts ...SearchOption) []ValueResult[V] {
+	o := _searchOptions(opts)
+	vt.mu.RLock()
+	defer vt.mu.RUnlock()
+	return vt._results(vt._search(key, &o))
+}
+
+// 前缀检索，返回相同前缀的词以及词对应的值
+func (vt *ValueTrie[V]) Prefix(pre string, limit int, opts ...SearchOption) ([]ValueResult[V], error) {
+	o := _searchOptions(opts)
+	vt.mu.RLock()
+	defer vt.mu.RUnlock()
+	result, err := vt._prefixWords(pre, limit, &o)
+	return vt._results(result), err
+}
+
+// 分页前缀检索，同时返回满足条件的词的总数
+func (vt *ValueTrie[V]) PrefixPage(pre string, limit int, opts ...SearchOption) ([]ValueResult[V], int, error) {
+	o := _searchOptions(opts)
+	vt.mu.RLock()
+	defer vt.mu.RUnlock()
+	result, total, err := vt._prefixPage(pre, limit, &o)
+	return vt._results(result), total, err
+}
+
+// 后缀检索，返回相同后缀的词以及词对应的值
+func (vt *ValueTrie[V]) Suffix(key string, limit int, opts ...SearchOption) ([]ValueResult[V], error) {
+	o := _searchOptions(opts)
+	vt.mu.RLock()
+	defer vt.mu.RUnlock()
+	result, err := vt._suffix(key, limit, &o)
+	return vt._results(result), err
+}
+
+// 模糊检索，返回查找到的词以及词对应的值
+func (vt *ValueTrie[V]) Fuzzy(key string, limit int, opts ...SearchOption) ([]ValueResult[V], error) {
+	o := _searchOptions(opts)
+	vt.mu.RLock()
+	defer vt.mu.RUnlock()
+	result, err := vt._fuzzy(key, limit, &o)
+	return vt._results(result), err
+}
+
+// 使用gob协议保存结构和所有的值
+// V需要是gob可以编码的类型
+func (vt *ValueTrie[V]) Store(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	vt.mu.RLock()
+	defer vt.mu.RUnlock()
+	return gob.NewEncoder(file).Encode(vt)
+}
+
+// 从指定路径加载结构和所有的值
+func (vt *ValueTrie[V]) Load(path string) error {
+	if vt.XTrie == nil {
+		vt.XTrie = new(XTrie)
+	}
+	vt.wmu.Lock()
+	defer vt.wmu.Unlock()
+	return vt._load(path)
+}
+
+func (vt *ValueTrie[V]) _load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	tmp := &ValueTrie[V]{XTrie: new(XTrie)}
+	err = gob.NewDecoder(file).Decode(tmp)
+	if err != nil {
+		return err
+	}
+	if err = vt._checkLoad(tmp.XTrie); err != nil {
+		return err
+	}
+	vt.mu.Lock()
+	vt._swap(tmp.XTrie)
+	vt.Values = tmp.Values
+	vt.mu.Unlock()
+	return nil
+}
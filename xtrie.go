@@ -3,6 +3,7 @@
 // 最优的使用场景通过自行维护词典文件来更新词库，该库的优点查询速度快。
 // 单个词插入(效率问题，可能会计算调整到整个结构所有的值)
 // 删除
+// 并发安全：检索之间互不阻塞，写操作串行执行，重新构建在新的结构上完成之后再替换
 
 package xtrie
 
@@ -12,107 +13,723 @@ import (
 	"log"
 	"os"
 	"sort"
+	"strconv"
+	"math/bits"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 )
 
 // x trie结构体 double array trie变种结构体
 // 数据结构更紧凑
+// Base和Check交错存储在int32切片Array中，Array[2i]为Base[i]，Array[2i+1]为Check[i]，检索时同一个节点的两个值在同一个缓存行
+// Base和Check的状态约定：
+// Check[i] > 0 父节点为Check[i]；Check[i] < 0 父节点为-Check[i]，并且该节点是词的结尾
+// Base[i] > 0 子节点偏移量；Base[i] < 0 没有子节点的词结尾，-Base[i]-1为词id
+// 有子节点的词结尾，词id存储在code为0的位置，即Base[Base[i]]
 type XTrie struct {
 	Fmd5  string  // 词典文件md5
-	Size  int     // 切片长度
-	Base  []int   // 基础切片，存储字符offset，正值和负值分别代表不同的状态
-	Check []int   // 检查字符状态数组，防止查找冲突以及确认多种状态
+	Size  int     // 节点数量，Array长度的一半
+	Array []int32 // Base和Check交错存储，Base存储字符offset，Check存储父节点，正值和负值分别代表不同的状态
 	Keys  [][]rune// 所有词典转成rune切片
 	StoreFile string //dat结构体序列化结果集
 	DictFile  string //词典文件路径
-	Keymap map[string]int //所有词对应等级
+	Keymap map[string]int //所有词对应的词id
+	Levels []int //词id对应的等级(权重)，可以是任意int值
+	MaxCode int  //所有词中最大的字符code，用于查找节点的所有子节点
+	Codes map[rune]int //字符对应的紧凑code，编译时按照字符出现次数从多到少分配，为空时直接使用字符值
+	Runes []rune       //code对应的字符，Runes[0]保留
+	ByteMode bool //按照UTF-8字节构建，每个字节是一个字符，字母表为256，检索不需要转换字符
+	ACMode   bool //开启AC自动机，编译时计算失败链接，内容检索只需要遍历一次内容
+	TailMode bool //开启TAIL压缩，没有分支的后缀存储在Tail中，不占用Base和Check的位置
+	Tail  []rune //所有没有分支的后缀，每个后缀以0结尾
+	Tails []int  //词id对应的后缀在Tail中的位置+1，0代表没有后缀
+	Normalizer Normalizer //文本规范化，编译、插入和检索时转换字符，不保存，加载store文件之前需要设置相同的Normalizer
+
+	mu  sync.RWMutex // 读写锁，检索持有读锁，修改结构持有写锁
+	wmu sync.Mutex   // 写操作互斥锁，保证插入、删除、重新构建串行执行
+	gen uint64       // 结构代数，每次修改或者替换结构加1
+	shared bool      // 结构数据是否被快照共享，共享时修改之前需要先复制
+	used []uint64    // 位置占用位图，查找偏移量时跳过已经占用的位置
+	free int         // 最小的空闲位置，之前的位置都已经占用
+	maxLen int       // 最长的词的字符数，删除词之后不减小；不保存，加载时计算
+	links *acLinks   // AC自动机链接，不保存，编译和加载时计算
+	tree atomic.Pointer[[]int32] // 子节点链接，tree[2i]为节点i的第一个子节点，tree[2i+1]为下一个兄弟节点，0代表没有；不保存，第一次前缀检索时生成
 }
 
 //重置基础数据
 func (x *XTrie) reset() {
-	x.Size = 65535
+	x.Size = 0
 	x.Fmd5 = ""
 	x.Keys = make([][]rune, 0, 1)
-	x.Base = make([]int, 0, 65535)
-	x.Check  = make([]int, 0, 65535)
+	x.Array = make([]int32, 0, 65535*2)
 	x.Keymap = make(map[string]int)
+	x.Levels = make([]int, 0)
+	x.maxLen = 0
+}
+
+// 设置词等级，词不存在时分配新的词id
+// 返回词id
+func (x *XTrie) _setLevel(key string, level int) int {
+	if x.Keymap == nil {
+		x.Keymap = make(map[string]int)
+	}
+	if id, ok := x.Keymap[key]; ok {
+		x.Levels[id] = level
+		return id
+	}
+	id := len(x.Levels)
+	x.Levels = append(x.Levels, level)
+	x.Keymap[key] = id
+	if n := utf8.RuneCountInString(key); n > x.maxLen {
+		x.maxLen = n
+	}
+	return id
+}
+
+// 节点的Base值
+func (x *XTrie) _base(index int) int {
+	return int(x.Array[index<<1])
+}
+
+// 节点的Check值
+func (x *XTrie) _check(index int) int {
+	return int(x.Array[index<<1|1])
+}
+
+// 设置节点的Base值
+func (x *XTrie) _setBase(index int, base int) {
+	x.Array[index<<1] = int32(base)
+}
+
+// 设置节点的Check值
+// 维护位置占用位图和子节点链接
+func (x *XTrie) _setCheck(index int, check int) {
+	old := x._check(index)
+	x.Array[index<<1|1] = int32(check)
+	if index <= 1 {
+		return
+	}
+	if tree := x._tree(); tree != nil {
+		_reparent(tree, index, old, check)
+	}
+	if x.used == nil {
+		return
+	}
+	if check == 0 {
+		x.used[index>>6] &^= 1 << (index & 63)
+		if index < x.free {
+			x.free = index
+		}
+	} else {
+		x.used[index>>6] |= 1 << (index & 63)
+	}
+}
+
+// 获取词结尾节点的词id
+func (x *XTrie) _wordID(index int) int {
+	if x._base(index) < 0 {
+		return -x._base(index) - 1
+	}
+	return -x._base(x._base(index)) - 1
+}
+
+// 获取节点的词等级，非词结尾返回0
+func (x *XTrie) _level(index int) int {
+	if x._check(index) >= 0 {
+		return 0
+	}
+	return x.Levels[x._wordID(index)]
+}
+
+// 读取字符串i位置的一个字符，字节模式下每个字节是一个字符
+// 返回字符和字符占用的字节数
+func (x *XTrie) _unit(s string, i int) (rune, int) {
+	if x.ByteMode || s[i] < utf8.RuneSelf {
+		return rune(s[i]), 1
+	}
+	return utf8.DecodeRuneInString(s[i:])
+}
+
+// 字符串的最后一个字符
+func (x *XTrie) _lastUnit(s string) (rune, int) {
+	if x.ByteMode {
+		return rune(s[len(s)-1]), 1
+	}
+	return utf8.DecodeLastRuneInString(s)
+}
+
+// 字符编码之后的字节数，无效的字符编码为utf8.RuneError
+func (x *XTrie) _unitLen(r rune) int {
+	if x.ByteMode {
+		return 1
+	}
+	if n := utf8.RuneLen(r); n > 0 {
+		return n
+	}
+	return utf8.RuneLen(utf8.RuneError)
+}
+
+// 后缀追加到buf中
+func (x *XTrie) _appendTail(buf []byte, tail []rune) []byte {
+	for _, r := range tail {
+		buf = x._appendUnit(buf, r)
+	}
+	return buf
+}
+
+// 字符串拆分为字符切片，字节模式下每个字节是一个字符
+func (x *XTrie) _units(s string) []rune {
+	if !x.ByteMode {
+		return []rune(s)
+	}
+	units := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		units[i] = rune(s[i])
+	}
+	return units
+}
+
+// 追加字符的编码
+func (x *XTrie) _appendUnit(buf []byte, r rune) []byte {
+	if x.ByteMode {
+		return append(buf, byte(r))
+	}
+	return utf8.AppendRune(buf, r)
+}
+
+// 字符切片还原为字符串
+func (x *XTrie) _string(units []rune) string {
+	if !x.ByteMode {
+		return string(units)
+	}
+	buf := make([]byte, 0, len(units))
+	for _, r := range units {
+		buf = append(buf, byte(r))
+	}
+	return string(buf)
+}
+
+// 判断字符串s从i位置开始是否是后缀tail，返回后缀结束的位置
+func (x *XTrie) _hasTail(s string, i int, tail []rune) (int, bool) {
+	for _, t := range tail {
+		if i >= len(s) {
+			return i, false
+		}
+		r, size := x._unit(s, i)
+		if r != t {
+			return i, false
+		}
+		i += size
+	}
+	return i, true
+}
+
+// 字符对应的code，词库中没有的字符返回0
+func (x *XTrie) _code(r rune) int {
+	if x.Codes == nil { //没有字符映射，直接使用字符值
+		return int(r)
+	}
+	return x.Codes[r]
+}
+
+// code对应的字符
+func (x *XTrie) _rune(code int) rune {
+	if x.Codes == nil {
+		return rune(code)
+	}
+	return x.Runes[code]
+}
+
+// 添加字符映射，已有的字符直接返回code
+func (x *XTrie) _addCode(r rune) int {
+	code := x._code(r)
+	if code == 0 {
+		code = len(x.Runes)
+		x.Runes = append(x.Runes, r)
+		x.Codes[r] = code
+	}
+	if code > x.MaxCode {
+		x.MaxCode = code
+	}
+	return code
+}
+
+// 统计所有词的字符出现次数，出现次数越多code越小，子节点更集中，结构更紧凑
+func (x *XTrie) _buildCodes() {
+	count := make(map[rune]int)
+	for _, key := range x.Keys {
+		for _, kv := range key {
+			count[kv]++
+		}
+	}
+	x.Runes = make([]rune, 1, len(count)+1)
+	for r := range count {
+		x.Runes = append(x.Runes, r)
+	}
+	runes := x.Runes[1:]
+	sort.Slice(runes, func(i, j int) bool {
+		if count[runes[i]] != count[runes[j]] {
+			return count[runes[i]] > count[runes[j]]
+		}
+		return runes[i] < runes[j]
+	})
+	x.Codes = make(map[rune]int, len(count))
+	for code, r := range runes {
+		x.Codes[r] = code + 1
+	}
+	x.MaxCode = len(runes)
+}
+
+// 没有子节点的词结尾节点在Tail中的后缀，没有后缀返回nil
+// 节点的词等于从根节点到该节点的字符加上后缀
+func (x *XTrie) _tail(index int) []rune {
+	if x._base(index) >= 0 || x._check(index) >= 0 || len(x.Tails) == 0 {
+		return nil
+	}
+	id := -x._base(index) - 1
+	if id >= len(x.Tails) || x.Tails[id] == 0 {
+		return nil
+	}
+	pos := x.Tails[id] - 1
+	end := pos
+	for x.Tail[end] != 0 {
+		end++
+	}
+	return x.Tail[pos:end]
+}
+
+// 设置词id对应的后缀，旧的后缀不回收，重新构建或者整理时释放
+func (x *XTrie) _setTail(id int, suffix []rune) {
+	for len(x.Tails) <= id {
+		x.Tails = append(x.Tails, 0)
+	}
+	x.Tails[id] = len(x.Tail) + 1
+	x.Tail = append(x.Tail, suffix...)
+	x.Tail = append(x.Tail, 0)
+}
+
+// 展开节点的后缀，后缀的每个字符添加为节点，词结尾标记移动到最后一个字符
+// 添加子节点时当前节点可能被移动，返回当前节点新的索引
+func (x *XTrie) _untail(index int) int {
+	tail := x._tail(index)
+	if tail == nil {
+		return index
+	}
+	id := -x._base(index) - 1
+	x.Tails[id] = 0
+	x._setBase(index, 0)
+	x._setCheck(index, -x._check(index))
+	node := index
+	for _, kv := range tail {
+		node = x._child(node, x._code(kv))
+	}
+	x._setBase(node, -id - 1)
+	x._setCheck(node, -x._check(node))
+	for range tail { //向上查找当前节点
+		node = x._check(node)
+		if node < 0 {
+			node = -node
+		}
+	}
+	return node
 }
 
 // 重置扩容base和check切片
-// 参数 newSize int 新的切片大小
+// 参数 newSize int 新的节点数量
 func (x *XTrie) resize(newSize int) int {
-	base2  := make([]int, newSize, newSize)
-	check2 := make([]int, newSize, newSize)
-	if len(x.Base) > 0 {
-		copy(base2, x.Base)
-		copy(check2, x.Check)
-	}
-	x.Base  = base2
-	x.Check = check2
+	if tree := x._tree(); tree != nil {
+		x._setTree(append(tree, make([]int32, newSize*2-len(tree))...))
+	}
+	if x._acLive() {
+		x.links._resize(newSize)
+	}
+	array := make([]int32, newSize*2)
+	copy(array, x.Array)
+	x.Array = array
 	x.Size  = newSize
+	for x.used != nil && len(x.used) < (newSize+63)/64+1 {
+		x.used = append(x.used, 0)
+	}
 	return newSize
 }
 
-// 构造词典，插入词，递归函数，直到找不到下一层深度的词
-// 参数 keyPre rune切片 前缀字符切片，查询词等级而设计的
-// 参数 children *Node切片 所有子节点
-// 参数 index int 上层index值
-func (x *XTrie) structure(keyPre []rune, children []*Node, index int) {
-	pos := children[0].Code //每次都以字符code起开始查找查找
-	//初始查找位置，根据父层索引加子节点最小code算出初始位置
-	childLen := len(children)
-	offset := 0
-outer:
-	for {
-		pos++
-		if pos >= x.Size {
-			x.resize(int(float64(offset + children[childLen - 1].Code) * 1.25))
-		}
-		if x.Base[pos] != 0 {
-			continue
+// 生成位置占用位图，0和根节点1始终占用
+// 位图不保存到store文件，加载或者重新构建之后第一次查找偏移量时生成
+func (x *XTrie) _initUsed() {
+	if x.used != nil {
+		return
+	}
+	x.used = make([]uint64, (x.Size+63)/64+1)
+	x.used[0] |= 3
+	for i := 2; i < x.Size; i++ {
+		if x._check(i) != 0 {
+			x.used[i>>6] |= 1 << (i & 63)
+		}
+	}
+	x.free = 2
+}
+
+// 位置是否被占用，超出范围的位置没有被占用
+func (x *XTrie) _isUsed(index int) bool {
+	return index>>6 < len(x.used) && x.used[index>>6]&(1<<(index&63)) != 0
+}
+
+// 从pos开始查找第一个空闲的位置，已经占满的64个位置一次跳过
+func (x *XTrie) _nextFree(pos int) int {
+	for w := pos >> 6; w < len(x.used); w++ {
+		free := ^x.used[w]
+		if w == pos>>6 {
+			free &= ^uint64(0) << (pos & 63)
+		}
+		if free != 0 {
+			return w<<6 + bits.TrailingZeros64(free)
+		}
+	}
+	if end := len(x.used) << 6; pos < end {
+		return end
+	}
+	return pos
+}
+
+// 从pos开始连续64个位置的占用情况，第k位对应pos+k，超出范围的位置没有被占用
+func (x *XTrie) _usedBits(pos int) uint64 {
+	w, b := pos>>6, uint(pos&63)
+	var v uint64
+	if w < len(x.used) {
+		v = x.used[w] >> b
+	}
+	if b != 0 && w+1 < len(x.used) {
+		v |= x.used[w+1] << (64 - b)
+	}
+	return v
+}
+
+// 查找可以放下所有code的偏移量
+// 使用位置占用位图，每次同时检查连续的64个偏移量，从最小的空闲位置开始查找
+// 参数 codes int切片 升序排列的子节点code
+func (x *XTrie) _findOffset(codes []int) int {
+	x._initUsed()
+	first, last := codes[0], codes[len(codes)-1]
+	x.free = x._nextFree(x.free)
+	offset := x.free - first
+	if offset < 1 { //偏移量至少为1
+		offset = 1
+	}
+	for ; ; offset += 64 {
+		fit := ^uint64(0) //第k位表示偏移量offset+k可以放下所有code
+		for _, code := range codes {
+			fit &^= x._usedBits(offset + code)
+			if fit == 0 {
+				break
+			}
+		}
+		if fit != 0 {
+			offset += bits.TrailingZeros64(fit)
+			break
+		}
+	}
+	if s := offset + last; s >= x.Size { //最大字符code位置超出范围
+		x.resize(int(float64(s+1) * 1.25))
+	}
+	return offset
+}
+
+// 节点的所有子节点code，包括code为0存储词id的位置
+func (x *XTrie) _children(index int) []int {
+	offset := x._base(index)
+	if offset <= 0 {
+		return nil
+	}
+	var codes []int
+	if tree := x._tree(); tree != nil {
+		for c := int(tree[index<<1]); c != 0; c = int(tree[c<<1|1]) {
+			codes = append(codes, c-offset)
 		}
-		offset = pos - children[0].Code
-		if s := offset + children[childLen - 1].Code; s > x.Size { //每次循环计算最大字符code位置是否超出范围
-			x.resize(int(float64(s) * 1.25))
+		sort.Ints(codes)
+		return codes
+	}
+	for code := 0; code <= x.MaxCode && offset+code < x.Size; code++ {
+		check := x._check(offset+code)
+		if check == index || check == -index {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// 已经生成的子节点链接，没有生成或者长度和结构不一致时返回nil
+func (x *XTrie) _tree() []int32 {
+	if tree := x.tree.Load(); tree != nil && len(*tree) == len(x.Array) && len(x.Array) > 0 {
+		return *tree
+	}
+	return nil
+}
+
+// 替换子节点链接，nil代表没有生成
+func (x *XTrie) _setTree(tree []int32) {
+	if tree == nil {
+		x.tree.Store(nil)
+		return
+	}
+	x.tree.Store(&tree)
+}
+
+// 获取子节点链接，没有时按照Check生成，之后插入、删除时随Check一起维护
+// 只有前缀检索使用，不检索前缀的结构不占用额外的内存(每个位置8字节)
+// 调用方持有读锁或者写锁，生成过程中结构不会变化，同时生成的链接内容相同，保存任意一个都可以
+func (x *XTrie) _loadTree() []int32 {
+	if tree := x._tree(); tree != nil || len(x.Array) == 0 {
+		return tree
+	}
+	tree := make([]int32, len(x.Array))
+	for i := x.Size - 1; i > 1; i-- { //从后向前插入，每个节点的子节点按照索引升序
+		if parent := x._check(i); parent != 0 {
+			_reparent(tree, i, 0, parent)
+		}
+	}
+	x._setTree(tree)
+	return tree
+}
+
+// Check从old修改为check之后，把节点从旧的父节点的子节点链接移动到新的父节点
+// code为0存储词id的位置也在子节点链接中，遍历时跳过
+func _reparent(tree []int32, index int, old int, check int) {
+	if old < 0 {
+		old = -old
+	}
+	if check < 0 {
+		check = -check
+	}
+	if old == check {
+		return
+	}
+	if old > 0 { //从旧的父节点中删除
+		if c := int(tree[old<<1]); c == index {
+			tree[old<<1] = tree[index<<1|1]
+		} else {
+			for ; c != 0; c = int(tree[c<<1|1]) {
+				if int(tree[c<<1|1]) == index {
+					tree[c<<1|1] = tree[index<<1|1]
+					break
+				}
+			}
+		}
+	}
+	tree[index<<1|1] = 0
+	if check > 0 { //插入到新的父节点的第一个子节点
+		tree[index<<1|1] = tree[check<<1]
+		tree[check<<1] = int32(index)
+	}
+}
+
+// 节点是否还有子节点，不包括code为0存储词id的位置
+func (x *XTrie) _hasChild(index int) bool {
+	offset := x._base(index)
+	if offset <= 0 {
+		return false
+	}
+	if tree := x._tree(); tree != nil {
+		for c := int(tree[index<<1]); c != 0; c = int(tree[c<<1|1]) {
+			if c != offset {
+				return true
+			}
+		}
+		return false
+	}
+	for code := 1; code <= x.MaxCode && offset+code < x.Size; code++ {
+		check := x._check(offset+code)
+		if check == index || check == -index {
+			return true
 		}
+	}
+	return false
+}
+
+// 释放节点位置
+func (x *XTrie) _free(index int) {
+	x._setBase(index, 0)
+	x._setCheck(index, 0)
+}
 
-		for i := 0; i < childLen; i++ {
-			//确保每一个子节点都能落到base和check中
-			ind := offset + children[i].Code
-			if x.Check[ind] != 0 || x.Base[ind] != 0 {
-				continue outer
+// 回收没有子节点的节点，并向上回收不再通向任何词的节点
+// 上级节点是词结尾并且只剩下存储词id的位置时，词id移回节点本身
+func (x *XTrie) _prune(index int) {
+	for index > 1 {
+		parent := x._check(index)
+		if parent < 0 {
+			parent = -parent
+		}
+		if x._acLive() {
+			x._acUnlink(index)
+		}
+		x._free(index)
+		if x._hasChild(parent) {
+			return
+		}
+		if x._check(parent) < 0 { //词结尾，只剩下code为0的位置
+			holder := x._base(parent)
+			x._setBase(parent, x._base(holder))
+			x._free(holder)
+			return
+		}
+		index = parent
+	}
+	x._setBase(1, 0) //所有的词都已经删除
+}
+
+// 移动节点的所有子节点到新的偏移量
+// 参数 index int 需要移动子节点的节点
+// 参数 children int切片 已有的子节点code
+// 参数 codes int切片 新偏移量需要容纳的所有code，升序排列
+func (x *XTrie) _relocate(index int, children []int, codes []int) {
+	old := x._base(index)
+	offset := x._findOffset(codes)
+	for _, code := range children {
+		from, to := old+code, offset+code
+		x._setBase(to, x._base(from))
+		x._setCheck(to, x._check(from))
+		if x._acLive() {
+			x._acMove(from, to)
+		}
+		//子节点的子节点指向新的位置
+		if x._base(from) > 0 {
+			for _, c := range x._children(from) {
+				ind := x._base(from) + c
+				if x._check(ind) < 0 {
+					x._setCheck(ind, -to)
+				} else {
+					x._setCheck(ind, to)
+				}
 			}
 		}
-		break
+		x._setBase(from, 0)
+		x._setCheck(from, 0)
+	}
+	x._setBase(index, offset)
+}
+
+// 查找节点code对应的子节点，不存在返回0
+func (x *XTrie) _next(index int, code int) int {
+	ind := x._base(index) + code
+	if x._base(index) <= 0 || ind >= x.Size {
+		return 0
+	}
+	if check := x._check(ind); check != index && check != -index {
+		return 0
 	}
+	return ind
+}
 
-	if x.Base[index] < 0 { //
-		x.Base[index] = offset * 10 + (-x.Base[index])
+// 查找节点code对应的子节点，不存在时添加子节点
+// 位置冲突时移动子节点较少的一方，当前节点也可能被移动，新的位置可以通过子节点的Check获取
+// 返回子节点的索引
+func (x *XTrie) _child(index int, code int) int {
+	if x._base(index) <= 0 { //没有子节点，词结尾节点需要把词id移动到code为0的位置
+		codes := []int{code}
+		if x._base(index) < 0 {
+			codes = []int{0, code}
+		}
+		offset := x._findOffset(codes)
+		if x._base(index) < 0 {
+			x._setBase(offset, x._base(index))
+			x._setCheck(offset, index)
+		}
+		x._setBase(index, offset)
 	} else {
-		x.Base[index] = offset
+		ind := x._base(index) + code
+		if ind >= x.Size {
+			x.resize(int(float64(ind+1) * 1.25))
+		}
+		if check := x._check(ind); check == index || check == -index { //子节点已经存在
+			return ind
+		}
+		if ind == 1 || x._check(ind) != 0 { //位置冲突
+			owner := x._check(ind)
+			if owner < 0 {
+				owner = -owner
+			}
+			children := x._children(index)
+			var others []int
+			if ind != 1 {
+				others = x._children(owner)
+			}
+			if ind == 1 || len(children) < len(others) {
+				codes := make([]int, 0, len(children)+1)
+				codes = append(codes, children...)
+				codes = append(codes, code)
+				sort.Ints(codes)
+				x._relocate(index, children, codes)
+			} else {
+				parent := x._check(index) //当前节点是冲突节点的兄弟节点时会被移动
+				if parent < 0 {
+					parent = -parent
+				}
+				pcode := index - x._base(owner)
+				x._relocate(owner, others, others)
+				if parent == owner {
+					index = x._base(owner) + pcode
+				}
+			}
+		}
+	}
+	ind := x._base(index) + code
+	x._setBase(ind, 0)
+	x._setCheck(ind, index)
+	return ind
+}
+
+// 构造词典，插入词，递归函数，直到找不到下一层深度的词
+// 参数 children *Node切片 所有子节点
+// 参数 index int 上层index值
+func (x *XTrie) structure(children []*Node, index int) {
+	childLen := len(children)
+	codes := make([]int, 0, childLen+1)
+	if x._base(index) < 0 { //当前节点是词结尾，预留code为0的位置存储词id
+		codes = append(codes, 0)
+	}
+	for i := 0; i < childLen; i++ {
+		codes = append(codes, children[i].Code)
+	}
+	sort.Ints(codes) //子节点按照字符排序，code不一定是升序
+	offset := x._findOffset(codes)
+
+	if x._base(index) < 0 {
+		x._setBase(offset, x._base(index))
+		x._setCheck(offset, index)
 	}
+	x._setBase(index, offset)
 
-	keyPre = append(keyPre, 1)
 	//写入所有的子节点到base中
 	//写入所有的子节点到check中
 	//必须先把所有节点写入完之后，再去查找添加下一层节点
 	for i := 0; i < childLen; i++ {
-		ind := offset + children[i].Code
-		if children[i].End {
-			keyPre[len(keyPre)-1] = rune(children[i].Code)
-			x.Base[ind] = -x.Keymap[string(keyPre)]
-			x.Check[ind] = -index
+		child := children[i]
+		ind := offset + child.Code
+		tail := x._tailMode() && !child.End && child.Right-child.Left == 1
+		if child.End || tail { //范围内的第一个词最短，词结尾时就是当前词
+			key := x.Keys[child.Left]
+			id := x.Keymap[x._string(key)]
+			x._setBase(ind, -id - 1)
+			x._setCheck(ind, -index)
+			if tail { //只剩下一个词，没有分支的后缀存储在Tail中
+				x._setTail(id, key[child.Depth:])
+			}
 		} else {
-			x.Base[ind] = 0
-			x.Check[ind] = index
+			x._setBase(ind, 0)
+			x._setCheck(ind, index)
 		}
 	}
 	//循环查找下一层节点并且插入dat结构中
 	for i := 0; i < childLen; i++ {
+		ind := offset + children[i].Code
+		if x._tail(ind) != nil {
+			continue
+		}
 		nodes := children[i].fetch(x)
 		if len(nodes) > 0 {
-			ind := offset + children[i].Code
-			keyPre[len(keyPre)-1] = rune(children[i].Code)
-			x.structure(keyPre, nodes, ind)
+			x.structure(nodes, ind)
 		}
 	}
 	return
@@ -131,7 +748,7 @@ func (x *XTrie) format() error {
 	sort.Strings(allKey)
 	x.Keys = make([][]rune, 0, len(allKey))
 	for _,key := range allKey {
-		x.Keys = append(x.Keys, []rune(key))
+		x.Keys = append(x.Keys, x._units(key))
 	}
 	return nil
 }
@@ -145,19 +762,148 @@ func (x *XTrie) build () error {
 	if err != nil {
 		return err
 	}
-	x.resize(len(x.Keys))
+	x.Array, x.used = nil, nil //重新构建，不保留旧的结构
+	x._setTree(nil)
+	x.Tail, x.Tails = nil, nil
+	if x.ByteMode { //字节直接作为code
+		x.Codes, x.Runes, x.MaxCode = nil, nil, 0
+		for _, key := range x.Keys {
+			for _, kv := range key {
+				if int(kv) > x.MaxCode {
+					x.MaxCode = int(kv)
+				}
+			}
+		}
+	} else {
+		x._buildCodes()
+	}
+	x.resize(len(x.Keys) + 2)
 	root := new(Node)
 	root.Left = 0
 	root.Right = len(x.Keys)
 	root.Depth = 0
 	children := root.fetch(x)
 	rootIndex := 1
-	x.structure([]rune{}, children, rootIndex)
+	x.structure(children, rootIndex)
+	if x.ACMode {
+		x.links = x._links()
+	}
+	return nil
+}
+
+// 创建只保留配置的空结构
+func (x *XTrie) _new() *XTrie {
+	return &XTrie{Fmd5: x.Fmd5, DictFile: x.DictFile, ByteMode: x.ByteMode, ACMode: x.ACMode, TailMode: x.TailMode, Normalizer: x.Normalizer}
+}
+
+// 复制所有的词到新的结构中，用于重新构建
+// 调用方需要持有wmu
+func (x *XTrie) _fork() *XTrie {
+	tmp := x._new()
+	tmp.Keymap = make(map[string]int, len(x.Keymap))
+	for k, v := range x.Keymap {
+		tmp.Keymap[k] = v
+	}
+	tmp.Levels = make([]int, len(x.Levels))
+	copy(tmp.Levels, x.Levels)
+	tmp.maxLen = x.maxLen
+	return tmp
+}
+
+// 替换为新构建的结构
+// 调用方持有写锁，只交换数据，检索要么使用旧的结构，要么使用新的结构
+func (x *XTrie) _swap(tmp *XTrie) {
+	x.Fmd5   = tmp.Fmd5
+	x.Size   = tmp.Size
+	x.Array  = tmp.Array
+	x.used   = tmp.used
+	x.free   = tmp.free
+	x.Keys   = tmp.Keys
+	x.Keymap = tmp.Keymap
+	x.Levels = tmp.Levels
+	x.maxLen = tmp.maxLen
+	x.MaxCode = tmp.MaxCode
+	x.Codes  = tmp.Codes
+	x.Runes  = tmp.Runes
+	x.Tail   = tmp.Tail
+	x.Tails  = tmp.Tails
+	x.gen++
+	x.shared = false
+	x._setTree(tmp._tree())
+	x.links = tmp.links
+	if x.links != nil {
+		x.links.gen = x.gen
+	}
+}
+
+// 在新的结构上编译，完成之后替换当前结构
+// 编译过程不持有读写锁，不会阻塞检索
+func (x *XTrie) _rebuild(tmp *XTrie) error {
+	err := tmp.build()
+	if err != nil {
+		return err
+	}
+	x.mu.Lock()
+	x._swap(tmp)
+	x.mu.Unlock()
 	return nil
 }
 
+// 截断末尾没有使用的位置，释放多余的容量
+func (x *XTrie) _trim() {
+	size := x.Size
+	for size > 2 && x._check(size-1) == 0 {
+		size--
+	}
+	array := make([]int32, size*2)
+	copy(array, x.Array)
+	if tree := x._tree(); tree != nil {
+		x._setTree(slices.Clip(tree[:size*2]))
+	}
+	if x._acLive() {
+		x.links._resize(size)
+	}
+	x.Array, x.Size = array, size
+	x.used = nil
+}
+
+// 结构占用的字节数，包括位置占用位图和子节点链接
+func (x *XTrie) _bytes() int {
+	n := cap(x.Tails)*strconv.IntSize/8 + (cap(x.Array)+cap(x.Tail))*4 + cap(x.used)*8
+	if tree := x.tree.Load(); tree != nil {
+		n += cap(*tree) * 4
+	}
+	return n
+}
+
+// 整理结构，重新排列所有节点填补删除和扩容留下的空位，并截断多余的长度
+// 在新的结构上整理，完成之后替换，整理过程中不阻塞检索，可以在goroutine中后台执行
+// 返回回收的字节数，和Insert一样只修改内存中的结构，需要持久化时调用Store
+func (x *XTrie) Compact() (int, error) {
+	x.wmu.Lock()
+	defer x.wmu.Unlock()
+	before := x._bytes()
+	tmp := x._fork()
+	if len(tmp.Keymap) == 0 { //所有的词都已经删除
+		tmp.resize(2)
+	} else if err := tmp.build(); err != nil {
+		return 0, err
+	}
+	tmp._trim()
+	after := tmp._bytes()
+	if after >= before { //重新排列没有变得更紧凑，保留当前结构
+		return 0, nil
+	}
+	x.mu.Lock()
+	x._swap(tmp)
+	x.mu.Unlock()
+	return before - after, nil
+}
+
 // 使用gob协议
 func (x *XTrie) Store(path string) error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, os.ModePerm)
 	if err != nil {
 		return err
@@ -172,7 +918,14 @@ func (x *XTrie) Store(path string) error {
 }
 
 // 从指定路径加载DAT
+// 加载到新的结构中，加载成功之后替换当前结构
 func (x *XTrie) Load(path string) error {
+	x.wmu.Lock()
+	defer x.wmu.Unlock()
+	return x._load(path)
+}
+
+func (x *XTrie) _load(path string) error {
 	file, err := os.Open(path)
 	if err != nil {
 		log.Println("dat build file open error", err)
@@ -181,29 +934,75 @@ func (x *XTrie) Load(path string) error {
 
 	defer file.Close()
 	decoder := gob.NewDecoder(file)
-	err = decoder.Decode(x)
+	tmp := new(XTrie)
+	err = decoder.Decode(tmp)
 	if err != nil {
 		log.Println("dat build file load error:", err)
 		return err
 	}
+	if err = x._checkLoad(tmp); err != nil {
+		return err
+	}
+	x.mu.Lock()
+	x._swap(tmp)
+	x.mu.Unlock()
 
 	return nil
 }
 
+// 检查加载的结构和当前的配置是否一致，并计算不保存的最长词字符数和AC自动机链接
+// XTrie和ValueTrie加载时共用
+func (x *XTrie) _checkLoad(tmp *XTrie) error {
+	if tmp.Size > 0 && len(tmp.Array) != tmp.Size*2 { //旧版本分开存储Base和Check的store文件，需要重新编译
+		return errors.New("store format changed")
+	}
+	if tmp.ByteMode != x.ByteMode { //字符和字节的结构不能混用
+		return errors.New("store ByteMode mismatch")
+	}
+	for key := range tmp.Keymap {
+		if n := utf8.RuneCountInString(key); n > tmp.maxLen {
+			tmp.maxLen = n
+		}
+	}
+	if x.ACMode { //AC自动机需要每个字符都是节点
+		if len(tmp.Tail) > 0 {
+			return errors.New("store TailMode mismatch")
+		}
+		tmp.ACMode = true
+		tmp.links = tmp._links()
+	}
+	return nil
+}
+
 // 初始化 double array
+// InitHandle中加载、编译和保存的操作，ValueTrie替换为同时处理值的版本
+type persister interface {
+	_load(path string) error
+	_rebuild(tmp *XTrie) error
+	Store(path string) error
+}
+
 // 加载store文件，读取词典，编译dat，保存store等
 func (x *XTrie) InitHandle(storeFile string, dictFile string) {
+	x._initHandle(x, storeFile, dictFile)
+}
+
+func (x *XTrie) _initHandle(p persister, storeFile string, dictFile string) {
+	x.wmu.Lock()
+	defer x.wmu.Unlock()
+	x.mu.Lock()
 	x.StoreFile = storeFile
 	x.DictFile  = dictFile
-	err := x.Load(x.StoreFile)
+	x.mu.Unlock()
+	err := p._load(x.StoreFile)
 	if err != nil { //加载失败
 		log.Println("load store", x.StoreFile, "error:", err)
 	} else {
 		log.Println("load store", x.StoreFile, "success")
 	}
 
-	status, err := x.DictRead()
-	if status == false {
+	tmp, err := x._dictRead()
+	if tmp != nil || err != nil {
 
 		if err != nil {
 			log.Fatalln("dict file read error:", err)
@@ -211,14 +1010,14 @@ func (x *XTrie) InitHandle(storeFile string, dictFile string) {
 			log.Println("dict file read success")
 		}
 
-		err = x.build()
+		err = p._rebuild(tmp)
 		if err != nil {
 			log.Fatalln("build error:", err)
 		} else {
 			log.Println("build success")
 		}
 
-		err = x.Store(x.StoreFile)
+		err = p.Store(x.StoreFile)
 		if err != nil {
 			log.Fatalln("store error:", err)
 		} else {